@@ -0,0 +1,118 @@
+package fdb
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// CompareAndSwap 当key存在且当前的value等于 old 时，将value替换为 new，返回是否替换成功
+// 读取和写入在同一次加锁中完成，写入是否持久化取决于 SyncWrite 配置，和 Put 一样替换之后的key永不过期
+func (db *DB) CompareAndSwap(key, old, new []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	var swapped bool
+	err := db.commit(func() (err error) {
+		swapped, err = db.compareAndSwapWithoutLock(key, old, new)
+		return err
+	}, db.options.SyncWrite)
+	if err != nil {
+		return false, err
+	}
+	return swapped, nil
+}
+
+// 读取当前的value并在相等时替换
+// 在访问此方法前必须持有互斥锁
+func (db *DB) compareAndSwapWithoutLock(key, old, new []byte) (bool, error) {
+	value, err := db.getWithoutLock(key)
+	if err == ErrKeyNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(value, old) {
+		return false, nil
+	}
+	if err = db.putWithoutLock(key, new, 0); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PutIfAbsent 当key不存在（或者已经过期）时写入key/value数据，返回是否写入
+func (db *DB) PutIfAbsent(key, value []byte) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+	var written bool
+	err := db.commit(func() (err error) {
+		written, err = db.putIfAbsentWithoutLock(key, value)
+		return err
+	}, db.options.SyncWrite)
+	if err != nil {
+		return false, err
+	}
+	return written, nil
+}
+
+// key不存在时写入key/value数据
+// 在访问此方法前必须持有互斥锁
+func (db *DB) putIfAbsentWithoutLock(key, value []byte) (bool, error) {
+	_, err := db.getWithoutLock(key)
+	if err == nil {
+		return false, nil
+	}
+	if err != ErrKeyNotFound {
+		return false, err
+	}
+	if err = db.putWithoutLock(key, value, 0); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Increment 将key对应的计数器加上 delta，并返回相加之后的值
+// 计数器以8字节大端序存储，key不存在时从0开始计数，value不是8字节时返回 ErrInvalidCounter
+// 计数器原有的过期时间会被保留
+func (db *DB) Increment(key []byte, delta int64) (int64, error) {
+	if len(key) == 0 {
+		return 0, ErrKeyIsEmpty
+	}
+	var counter int64
+	err := db.commit(func() (err error) {
+		counter, err = db.incrementWithoutLock(key, delta)
+		return err
+	}, db.options.SyncWrite)
+	if err != nil {
+		return 0, err
+	}
+	return counter, nil
+}
+
+// 读取计数器并写入相加之后的值
+// 在访问此方法前必须持有互斥锁
+func (db *DB) incrementWithoutLock(key []byte, delta int64) (int64, error) {
+	var counter, expire int64
+	value, err := db.getWithoutLock(key)
+	switch err {
+	case nil:
+		if len(value) != 8 {
+			return 0, ErrInvalidCounter
+		}
+		counter = int64(binary.BigEndian.Uint64(value))
+		expire = db.index.Get(key).Expire
+	case ErrKeyNotFound:
+	default:
+		return 0, err
+	}
+
+	counter += delta
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(counter))
+	if err = db.putWithoutLock(key, buf, expire); err != nil {
+		return 0, err
+	}
+	return counter, nil
+}
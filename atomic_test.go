@@ -0,0 +1,116 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDB_CompareAndSwap(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-cas")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.key不存在
+	ok, err := db.CompareAndSwap(utils.GetTestKey(1), nil, []byte("v1"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 2.当前值不相等
+	err = db.Put(utils.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+	ok, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("v0"), []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// 3.当前值相等
+	ok, err = db.CompareAndSwap(utils.GetTestKey(1), []byte("v1"), []byte("v2"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+
+	_, err = db.CompareAndSwap(nil, nil, nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+}
+
+func TestDB_PutIfAbsent(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-put-if-absent")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ok, err := db.PutIfAbsent(utils.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = db.PutIfAbsent(utils.GetTestKey(1), []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+
+	// 过期的key视为不存在
+	err = db.PutWithTTL(utils.GetTestKey(2), []byte("v1"), 50*time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+	ok, err = db.PutIfAbsent(utils.GetTestKey(2), []byte("v2"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+}
+
+func TestDB_Increment(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-increment")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 并发累加不会丢失更新
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := db.Increment(utils.GetTestKey(1), 1)
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	counter, err := db.Increment(utils.GetTestKey(1), -500)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(500), counter)
+
+	// 重启之后计数器依然有效
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	counter, err = db2.Increment(utils.GetTestKey(1), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(500), counter)
+
+	// value不是计数器
+	err = db2.Put(utils.GetTestKey(2), []byte("not a counter"))
+	assert.Nil(t, err)
+	_, err = db2.Increment(utils.GetTestKey(2), 1)
+	assert.Equal(t, ErrInvalidCounter, err)
+}
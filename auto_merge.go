@@ -0,0 +1,84 @@
+package fdb
+
+import (
+	"time"
+)
+
+// 启动后台自动 merge 的协程，每隔 CheckInterval 检查一次是否满足 merge 的条件
+func (db *DB) startAutoMerge() {
+	db.autoMergeStop = make(chan struct{})
+	db.autoMergeDone = make(chan struct{})
+	go db.autoMerge()
+}
+
+// 通知后台自动 merge 的协程退出，并等待正在进行的 merge 完成
+func (db *DB) stopAutoMerge() {
+	if db.autoMergeStop == nil {
+		return
+	}
+	close(db.autoMergeStop)
+	<-db.autoMergeDone
+	db.autoMergeStop = nil
+}
+
+func (db *DB) autoMerge() {
+	defer close(db.autoMergeDone)
+
+	ticker := time.NewTicker(db.options.AutoMerge.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.autoMergeStop:
+			return
+		case now := <-ticker.C:
+			if !db.shouldAutoMerge(now) {
+				continue
+			}
+			var err error
+			if db.options.AutoMerge.Selective {
+				if fids := db.PickMergeFiles(); len(fids) > 0 {
+					err = db.MergeFiles(fids)
+				} else {
+					err = ErrMergeRatioUnreached
+				}
+			} else {
+				err = db.Merge()
+			}
+			switch err {
+			// merge 之后的数据文件在下次启动时才会生效，在此之前 merge 会返回 ErrMergeNotApplied，继续检查直到关闭数据库
+			case nil, ErrMergeRatioUnreached, ErrMergeIsProgress, ErrMergeNotApplied:
+			default:
+				if db.options.AutoMerge.OnError != nil {
+					db.options.AutoMerge.OnError(err)
+				}
+				db.listener.OnBackgroundError(err)
+			}
+		}
+	}
+}
+
+// 是否处于允许 merge 的时间窗口内，并且可以回收的数据量达到了阀值
+// 无效数据占比由 Merge 自己检查
+func (db *DB) shouldAutoMerge(now time.Time) bool {
+	opts := db.options.AutoMerge
+	if !inMergeWindow(now, opts.WindowStart, opts.WindowEnd) {
+		return false
+	}
+	db.mu.RLock()
+	reclaimSize := db.reclaimSize
+	db.mu.RUnlock()
+	return reclaimSize > 0 && reclaimSize >= opts.MinReclaimSize
+}
+
+// 判断时间是否处于 [start, end) 的时间窗口内，end 小于 start 表示窗口跨越零点
+func inMergeWindow(now time.Time, start, end time.Duration) bool {
+	if start == end {
+		return true
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+	if start < end {
+		return offset >= start && offset < end
+	}
+	return offset >= start || offset < end
+}
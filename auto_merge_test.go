@@ -0,0 +1,86 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDB_AutoMerge(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-auto-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0.2
+	opts.AutoMerge.Enable = true
+	opts.AutoMerge.CheckInterval = 20 * time.Millisecond
+	opts.AutoMerge.MinReclaimSize = 64 * 1024
+	var mergeErr error
+	opts.AutoMerge.OnError = func(err error) {
+		mergeErr = err
+	}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 等待后台完成 merge
+	mergeFinishedFileName := filepath.Join(db.getMergePath(), data.MergeFinishedFileName)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(mergeFinishedFileName)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	// merge 之后后台协程继续运行，还没有生效的 merge 不会作为错误上报
+	time.Sleep(5 * opts.AutoMerge.CheckInterval)
+	select {
+	case <-db.autoMergeDone:
+		assert.Fail(t, "auto merge stopped after the first merge")
+	default:
+	}
+
+	err = db.Close()
+	assert.Nil(t, err)
+	assert.Nil(t, mergeErr)
+
+	// 重启之后使用 merge 之后的数据文件
+	opts.AutoMerge.Enable = false
+	db2, err := Open(opts)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(db2.ListKeys()))
+	assert.Equal(t, int64(0), db2.Stat().ReclaimSize)
+	for i := 500; i < 1000; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+}
+
+func TestInMergeWindow(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2024, 1, 1, hour, 30, 0, 0, time.Local)
+	}
+	// 不限制时间
+	assert.True(t, inMergeWindow(at(12), 0, 0))
+	// 2点到6点
+	assert.True(t, inMergeWindow(at(3), 2*time.Hour, 6*time.Hour))
+	assert.False(t, inMergeWindow(at(7), 2*time.Hour, 6*time.Hour))
+	// 22点到第二天4点
+	assert.True(t, inMergeWindow(at(23), 22*time.Hour, 4*time.Hour))
+	assert.True(t, inMergeWindow(at(1), 22*time.Hour, 4*time.Hour))
+	assert.False(t, inMergeWindow(at(12), 22*time.Hour, 4*time.Hour))
+}
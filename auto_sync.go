@@ -0,0 +1,65 @@
+package fdb
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 启动后台定时持久化的协程，每隔 SyncInterval 持久化一次还没有持久化的写入
+func (db *DB) startAutoSync() {
+	db.autoSyncStop = make(chan struct{})
+	db.autoSyncDone = make(chan struct{})
+	go db.autoSync()
+}
+
+// 通知后台定时持久化的协程退出，并等待正在进行的持久化完成
+func (db *DB) stopAutoSync() {
+	if db.autoSyncStop == nil {
+		return
+	}
+	close(db.autoSyncStop)
+	<-db.autoSyncDone
+	db.autoSyncStop = nil
+}
+
+func (db *DB) autoSync() {
+	defer close(db.autoSyncDone)
+
+	ticker := time.NewTicker(db.options.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.autoSyncStop:
+			return
+		case <-ticker.C:
+			db.mu.Lock()
+			// 没有新的写入时不需要持久化，持久化失败时累计值不会被清空，下次继续尝试
+			if db.bytesWrite > 0 {
+				if err := db.syncActiveFile(); err != nil {
+					db.listener.OnBackgroundError(err)
+				}
+			}
+			db.mu.Unlock()
+		}
+	}
+}
+
+// 持久化 blob 文件和当前活跃文件，清空累计的写入字节数并记录持久化的时间
+// 在访问此方法前必须持有互斥锁
+func (db *DB) syncActiveFile() error {
+	if db.activeFile == nil {
+		return nil
+	}
+	// 先持久化 blob 文件，保证数据文件中的 blob 位置记录指向的数据已经持久化
+	if err := db.syncBlobFile(); err != nil {
+		return err
+	}
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
+	db.bytesWrite = 0
+	db.lastSyncTime = time.Now()
+	atomic.AddUint64(&db.metrics.syncCount, 1)
+	db.listener.OnSync(db.activeFile.FileId)
+	return nil
+}
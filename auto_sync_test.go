@@ -0,0 +1,53 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDB_AutoSync(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-auto-sync")
+	opts.DirPath = dir
+	opts.SyncInterval = 20 * time.Millisecond
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 没有写入时不需要持久化
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, db.Stat().LastSyncTime.IsZero())
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	// 等待后台完成持久化
+	assert.Eventually(t, func() bool {
+		return db.Stat().LastSyncTime.After(start)
+	}, 5*time.Second, 10*time.Millisecond)
+	db.mu.RLock()
+	assert.Equal(t, uint(0), db.bytesWrite)
+	db.mu.RUnlock()
+
+	// 手动持久化也会更新持久化的时间
+	lastSync := db.Stat().LastSyncTime
+	err = db.Put(utils.GetTestKey(100), utils.RandomValue(128))
+	assert.Nil(t, err)
+	err = db.Sync()
+	assert.Nil(t, err)
+	assert.True(t, db.Stat().LastSyncTime.After(lastSync))
+
+	err = db.Close()
+	assert.Nil(t, err)
+	assert.Nil(t, db.autoSyncStop)
+
+	opts.SyncInterval = -1
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,384 @@
+package fdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/fio"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const backupManifestFileName = "backup-manifest"
+
+// BackupManifest 备份清单，记录了恢复出完整数据目录所需要的所有文件及其数据段
+// 增量备份只保存新增的数据段，之前的数据段引用上一次备份目录中的文件
+// 清单中的目录都是相对于清单所在备份目录的路径，整个备份链可以一起移动到其他位置
+type BackupManifest struct {
+	Parent         string        `json:"parent"`            // 上一次备份的目录，为空表示全量备份
+	CreatedAt      time.Time     `json:"created_at"`        // 备份时间
+	NonMergeFileId uint32        `json:"non_merge_file_id"` // 备份时被 merge 重写过的文件ID上界，没有 merge 为0
+	Files          []*BackupFile `json:"files"`             // 数据目录中的文件
+}
+
+// BackupFile 备份的文件
+type BackupFile struct {
+	Name       string           `json:"name"`                 // 文件名称
+	FileId     *uint32          `json:"file_id,omitempty"`    // 数据文件ID，非数据文件为空
+	Generation uint32           `json:"generation,omitempty"` // 数据文件被 MergeFiles、Repair 原地重写过的版本
+	Size       int64            `json:"size"`                 // 文件大小
+	Segments   []*BackupSegment `json:"segments"`             // 按照偏移量排列的数据段，拼接起来就是完整的文件
+}
+
+// BackupSegment 文件中的一段数据
+type BackupSegment struct {
+	Backup string `json:"backup"` // 存放该数据段的备份目录，相对于备份清单所在的目录
+	Offset int64  `json:"offset"` // 在文件中的偏移量
+	Length int64  `json:"length"` // 数据段的长度
+	CRC32  uint32 `json:"crc32"`  // 数据段的校验值
+}
+
+// 备份时数据目录中文件的状态
+type backupSource struct {
+	name       string
+	fileId     *uint32
+	generation uint32
+	size       int64
+	file       *os.File // 非数据文件在持有锁时打开，之后被替换也能读到备份时的内容，数据文件为空
+	appendOnly bool     // blob 文件只会追加写，不会被 merge 替换
+}
+
+// BackupIncremental 增量备份数据库到 dir 目录，并在其中写入备份清单
+// parentDir 为上一次备份的目录，为空时进行全量备份
+// 数据文件是追加写的，增量备份只拷贝新的数据文件及已有文件新增的部分，被 merge 替换或者原地重写的文件会重新全量拷贝
+// 只在确定文件列表及大小时持有读锁，拷贝数据时不阻塞写入
+func (db *DB) BackupIncremental(dir, parentDir string) (*BackupManifest, error) {
+	var parent *BackupManifest
+	if parentDir != "" {
+		var err error
+		if parent, err = LoadBackupManifest(parentDir); err != nil {
+			return nil, err
+		}
+		if parentDir, err = filepath.Abs(parentDir); err != nil {
+			return nil, err
+		}
+	}
+	backupDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(backupDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	sources, nonMergeFileId, err := db.backupSources()
+	if err != nil {
+		return nil, err
+	}
+	defer db.unpinBlobFiles()
+	defer closeBackupSources(sources)
+
+	parentFiles := make(map[string]*BackupFile)
+	if parent != nil {
+		for _, file := range parent.Files {
+			parentFiles[file.Name] = file
+		}
+	}
+
+	manifest := &BackupManifest{
+		CreatedAt:      time.Now(),
+		NonMergeFileId: nonMergeFileId,
+	}
+	if parent != nil {
+		if manifest.Parent, err = filepath.Rel(backupDir, parentDir); err != nil {
+			return nil, err
+		}
+	}
+	for _, source := range sources {
+		file := &BackupFile{Name: source.name, FileId: source.fileId, Generation: source.generation, Size: source.size}
+		var offset int64
+
+		// 数据文件没有被 merge 替换、没有被原地重写，并且没有变小，则复用上一次备份的数据段，blob 文件不会被 merge 替换
+		if prev := parentFiles[source.name]; prev != nil && prev.Size <= source.size && (source.appendOnly ||
+			source.fileId != nil && prev.Generation == source.generation &&
+				(parent.NonMergeFileId == nonMergeFileId || *source.fileId >= nonMergeFileId)) {
+			// 上一次备份的数据段的目录相对于上一次备份的目录，转换为相对于这次备份的目录
+			for _, segment := range prev.Segments {
+				segmentDir, err := filepath.Rel(backupDir, segment.dir(parentDir))
+				if err != nil {
+					return nil, err
+				}
+				reused := *segment
+				reused.Backup = segmentDir
+				file.Segments = append(file.Segments, &reused)
+			}
+			offset = prev.Size
+		}
+
+		if offset < source.size {
+			segment, err := writeBackupSegment(db.options.DirPath, backupDir, source, offset)
+			if err != nil {
+				return nil, err
+			}
+			file.Segments = append(file.Segments, segment)
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(filepath.Join(backupDir, backupManifestFileName), content, fio.DataFilePerm); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// LoadBackupManifest 读取备份目录中的备份清单
+func LoadBackupManifest(dir string) (*BackupManifest, error) {
+	content, err := os.ReadFile(filepath.Join(dir, backupManifestFileName))
+	if err != nil {
+		return nil, err
+	}
+	manifest := &BackupManifest{}
+	if err = json.Unmarshal(content, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// RestoreBackup 根据备份目录中的备份清单，从备份链中拼接出完整的数据目录
+// targetDir 必须不存在或者为空
+func RestoreBackup(backupDir, targetDir string) error {
+	manifest, err := LoadBackupManifest(backupDir)
+	if err != nil {
+		return err
+	}
+	if entries, err := os.ReadDir(targetDir); err == nil && len(entries) > 0 {
+		return ErrRestoreDirNotEmpty
+	}
+	if err = os.MkdirAll(targetDir, os.ModePerm); err != nil {
+		return err
+	}
+
+	for _, file := range manifest.Files {
+		if err = restoreBackupFile(file, backupDir, targetDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 确定需要备份的文件及大小，非数据文件只在这里打开，拷贝时不再持有锁
+// 备份期间固定住 blob 文件，成功返回之后调用方需要调用 unpinBlobFiles 和 closeBackupSources 释放
+func (db *DB) backupSources() (sources []*backupSource, nonMergeFileId uint32, err error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	defer func() {
+		if err != nil {
+			closeBackupSources(sources)
+		}
+	}()
+
+	if nonMergeFileId, err = db.mergedFileIdBound(); err != nil {
+		return nil, 0, err
+	}
+
+	for _, fid := range db.sortedFileIds() {
+		fileId := fid
+		dataFile := db.dataFileById(fid)
+		size := dataFile.WriteOff
+		if dataFile != db.activeFile {
+			if size, err = dataFile.IoManager.Size(); err != nil {
+				return nil, 0, err
+			}
+		}
+		sources = append(sources, &backupSource{
+			name:       filepath.Base(data.GetDataFileName(db.options.DirPath, fid)),
+			fileId:     &fileId,
+			generation: db.rewrittenFiles[fid],
+			size:       size,
+		})
+	}
+
+	// blob 文件通常很大，和数据文件一样在拷贝时才读取，已经被压缩的 blob 文件不需要备份
+	blobFileIds := make([]uint32, 0, len(db.olderBlobFiles)+1)
+	for fid := range db.olderBlobFiles {
+		blobFileIds = append(blobFileIds, fid)
+	}
+	if db.activeBlobFile != nil {
+		blobFileIds = append(blobFileIds, db.activeBlobFile.FileId)
+	}
+	sort.Slice(blobFileIds, func(i, j int) bool {
+		return blobFileIds[i] < blobFileIds[j]
+	})
+	for _, fid := range blobFileIds {
+		blobFile := db.blobFileById(fid)
+		size := blobFile.WriteOff
+		if blobFile != db.activeBlobFile {
+			if size, err = blobFile.IoManager.Size(); err != nil {
+				return nil, 0, err
+			}
+		}
+		sources = append(sources, &backupSource{
+			name:       filepath.Base(data.GetBlobFileName(db.options.DirPath, fid)),
+			size:       size,
+			appendOnly: true,
+		})
+	}
+
+	entries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == dbFileLock || strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) ||
+			strings.HasSuffix(entry.Name(), data.BlobFileNameSuffix) {
+			continue
+		}
+		// hint 文件和索引检查点都是写入临时文件之后重命名替换的，打开的文件不会再被修改
+		file, err := os.Open(filepath.Join(db.options.DirPath, entry.Name()))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return sources, 0, err
+		}
+		fileInfo, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			return sources, 0, err
+		}
+		sources = append(sources, &backupSource{
+			name: entry.Name(),
+			size: fileInfo.Size(),
+			file: file,
+		})
+	}
+	db.pinBlobFiles()
+	return sources, nonMergeFileId, nil
+}
+
+// 读取文件从 offset 开始到备份时大小的数据，非数据文件使用确定备份文件时打开的文件
+func openBackupSource(dirPath string, source *backupSource, offset int64) (io.Reader, func(), error) {
+	if source.file != nil {
+		return io.NewSectionReader(source.file, offset, source.size-offset), func() {}, nil
+	}
+	srcFile, err := os.Open(filepath.Join(dirPath, source.name))
+	if err != nil {
+		return nil, nil, err
+	}
+	return io.NewSectionReader(srcFile, offset, source.size-offset), func() {
+		_ = srcFile.Close()
+	}, nil
+}
+
+// 关闭确定备份文件时打开的文件
+func closeBackupSources(sources []*backupSource) {
+	for _, source := range sources {
+		if source.file != nil {
+			_ = source.file.Close()
+		}
+	}
+}
+
+// 将文件从 offset 开始的数据拷贝到备份目录中
+func writeBackupSegment(dirPath, backupDir string, source *backupSource, offset int64) (*BackupSegment, error) {
+	reader, closeSource, err := openBackupSource(dirPath, source, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer closeSource()
+
+	destFile, err := os.OpenFile(filepath.Join(backupDir, backupSegmentName(source.name, offset)),
+		os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fio.DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = destFile.Close()
+	}()
+
+	hash := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(destFile, hash), reader)
+	if err != nil {
+		return nil, err
+	}
+	if err = destFile.Sync(); err != nil {
+		return nil, err
+	}
+	return &BackupSegment{
+		Backup: ".",
+		Offset: offset,
+		Length: n,
+		CRC32:  hash.Sum32(),
+	}, nil
+}
+
+// 将文件的所有数据段拼接起来恢复到目标目录中，并校验每个数据段
+// backupDir 为备份清单所在的目录，数据段的目录相对于该目录
+func restoreBackupFile(file *BackupFile, backupDir, targetDir string) error {
+	destFile, err := os.OpenFile(filepath.Join(targetDir, file.Name), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = destFile.Close()
+	}()
+
+	var offset int64
+	for _, segment := range file.Segments {
+		if segment.Offset != offset {
+			return fmt.Errorf("%w: %s segment at offset %d is missing", ErrBackupCorrupted, file.Name, offset)
+		}
+		if err = copyBackupSegment(destFile, backupDir, file.Name, segment); err != nil {
+			return err
+		}
+		offset += segment.Length
+	}
+	if offset != file.Size {
+		return fmt.Errorf("%w: %s size mismatch", ErrBackupCorrupted, file.Name)
+	}
+	return destFile.Sync()
+}
+
+// 拷贝数据段到目标文件中，并校验长度和校验值
+func copyBackupSegment(destFile io.Writer, backupDir, name string, segment *BackupSegment) error {
+	segmentFile, err := os.Open(filepath.Join(segment.dir(backupDir), backupSegmentName(name, segment.Offset)))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = segmentFile.Close()
+	}()
+
+	hash := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(destFile, hash), segmentFile)
+	if err != nil {
+		return err
+	}
+	if n != segment.Length || hash.Sum32() != segment.CRC32 {
+		return fmt.Errorf("%w: %s segment at offset %d has invalid checksum", ErrBackupCorrupted, name, segment.Offset)
+	}
+	return nil
+}
+
+// 存放数据段的备份目录，backupDir 为备份清单所在的目录，兼容之前记录的绝对路径
+func (segment *BackupSegment) dir(backupDir string) string {
+	if filepath.IsAbs(segment.Backup) {
+		return segment.Backup
+	}
+	return filepath.Join(backupDir, segment.Backup)
+}
+
+// 数据段在备份目录中的文件名称
+func backupSegmentName(name string, offset int64) string {
+	return name + "." + strconv.FormatInt(offset, 10) + ".seg"
+}
@@ -0,0 +1,124 @@
+package fdb
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"github.com/calmw/fdb/fio"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// BackupTo 将数据库备份为一个 tar 包（可选 gzip 压缩）写入 w，不需要在本地落盘
+// 只在确定文件列表及大小时持有读锁，之后写入的数据不会包含在备份中，拷贝数据文件时不阻塞写入
+func (db *DB) BackupTo(w io.Writer, opts BackupOptions) error {
+	sources, _, err := db.backupSources()
+	if err != nil {
+		return err
+	}
+	defer db.unpinBlobFiles()
+	defer closeBackupSources(sources)
+
+	var gzipWriter *gzip.Writer
+	if opts.Gzip {
+		gzipWriter = gzip.NewWriter(w)
+		w = gzipWriter
+	}
+	tarWriter := tar.NewWriter(w)
+
+	modTime := time.Now()
+	for _, source := range sources {
+		header := &tar.Header{
+			Name:    source.name,
+			Mode:    fio.DataFilePerm,
+			Size:    source.size,
+			ModTime: modTime,
+		}
+		if err = tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if err = writeBackupSource(tarWriter, db.options.DirPath, source); err != nil {
+			return err
+		}
+	}
+
+	if err = tarWriter.Close(); err != nil {
+		return err
+	}
+	if gzipWriter != nil {
+		return gzipWriter.Close()
+	}
+	return nil
+}
+
+// RestoreFrom 从 BackupTo 生成的 tar 包（自动识别 gzip 压缩）中恢复数据目录
+// dir 必须不存在或者为空
+func RestoreFrom(r io.Reader, dir string) error {
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return ErrRestoreDirNotEmpty
+	}
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	// 根据魔数判断是否经过 gzip 压缩
+	bufReader := bufio.NewReader(r)
+	r = bufReader
+	if magic, err := bufReader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzipReader, err := gzip.NewReader(bufReader)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = gzipReader.Close()
+		}()
+		r = gzipReader
+	}
+
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		// 备份中只有数据目录下的普通文件
+		if header.Typeflag != tar.TypeReg || header.Name != filepath.Base(header.Name) ||
+			header.Name == ".." || header.Name == "." {
+			return ErrInvalidBackupArchive
+		}
+		if err = restoreArchiveFile(tarReader, filepath.Join(dir, header.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 将备份的文件内容写入 w，数据文件只写入到备份时的大小
+func writeBackupSource(w io.Writer, dirPath string, source *backupSource) error {
+	reader, closeSource, err := openBackupSource(dirPath, source, 0)
+	if err != nil {
+		return err
+	}
+	defer closeSource()
+	_, err = io.Copy(w, reader)
+	return err
+}
+
+func restoreArchiveFile(r io.Reader, fileName string) error {
+	destFile, err := os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = destFile.Close()
+	}()
+	if _, err = io.Copy(destFile, r); err != nil {
+		return err
+	}
+	return destFile.Sync()
+}
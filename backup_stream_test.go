@@ -0,0 +1,84 @@
+package fdb
+
+import (
+	"archive/tar"
+	"bytes"
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_BackupTo(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-backup-to")
+	opts.DirPath = dir
+	opts.DataFileSize = 8 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	restoreRoot, _ := os.MkdirTemp("", "fdb-go-backup-to-restore")
+	defer func() {
+		_ = os.RemoveAll(restoreRoot)
+	}()
+
+	for i := 0; i < 200; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+
+	for _, backupOpts := range []BackupOptions{{Gzip: true}, {Gzip: false}} {
+		var buf bytes.Buffer
+		err = db.BackupTo(&buf, backupOpts)
+		assert.Nil(t, err)
+
+		// 备份之后的写入不影响备份的内容
+		err = db.Put(utils.GetTestKey(1000), utils.RandomValue(64))
+		assert.Nil(t, err)
+
+		restoreDir := filepath.Join(restoreRoot, "gzip")
+		if !backupOpts.Gzip {
+			restoreDir = filepath.Join(restoreRoot, "tar")
+		}
+		err = RestoreFrom(&buf, restoreDir)
+		assert.Nil(t, err)
+
+		restoreOpts := opts
+		restoreOpts.DirPath = restoreDir
+		db2, err := Open(restoreOpts)
+		assert.Nil(t, err)
+		assert.Equal(t, 200, len(db2.ListKeys()))
+		val1, err := db.Get(utils.GetTestKey(10))
+		assert.Nil(t, err)
+		val2, err := db2.Get(utils.GetTestKey(10))
+		assert.Nil(t, err)
+		assert.Equal(t, val1, val2)
+		_ = db2.Close()
+
+		err = db.Delete(utils.GetTestKey(1000))
+		assert.Nil(t, err)
+	}
+}
+
+func TestRestoreFrom_InvalidArchive(t *testing.T) {
+	restoreDir, _ := os.MkdirTemp("", "fdb-go-restore-invalid")
+	defer func() {
+		_ = os.RemoveAll(restoreDir)
+	}()
+
+	// 包含路径的文件不能恢复到数据目录之外
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	content := []byte("evil")
+	err := tarWriter.WriteHeader(&tar.Header{Name: "../evil.data", Mode: 0644, Size: int64(len(content))})
+	assert.Nil(t, err)
+	_, err = tarWriter.Write(content)
+	assert.Nil(t, err)
+	assert.Nil(t, tarWriter.Close())
+
+	err = RestoreFrom(&buf, restoreDir)
+	assert.Equal(t, ErrInvalidBackupArchive, err)
+}
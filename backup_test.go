@@ -0,0 +1,223 @@
+package fdb
+
+import (
+	"bytes"
+	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_BackupIncremental(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-backup-incremental")
+	opts.DirPath = dir
+	opts.DataFileSize = 8 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	backupRoot, _ := os.MkdirTemp("", "fdb-go-backup-incremental-dest")
+	defer func() {
+		_ = os.RemoveAll(backupRoot)
+	}()
+
+	for i := 0; i < 200; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+
+	// 全量备份
+	fullDir := filepath.Join(backupRoot, "full")
+	full, err := db.BackupIncremental(fullDir, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "", full.Parent)
+
+	// 增量备份只拷贝新增的数据
+	for i := 200; i < 300; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.Delete(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	incrDir := filepath.Join(backupRoot, "incr")
+	incr, err := db.BackupIncremental(incrDir, fullDir)
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join("..", "full"), incr.Parent)
+
+	var copied, total int64
+	for _, file := range incr.Files {
+		total += file.Size
+		for _, segment := range file.Segments {
+			if segment.Backup == "." {
+				copied += segment.Length
+			}
+		}
+	}
+	assert.Less(t, copied, total/2)
+
+	// 从增量备份恢复
+	restoreDir := filepath.Join(backupRoot, "restore")
+	err = RestoreBackup(incrDir, restoreDir)
+	assert.Nil(t, err)
+	restoreOpts := opts
+	restoreOpts.DirPath = restoreDir
+	db2, err := Open(restoreOpts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 299, len(db2.ListKeys()))
+	for i := 1; i < 300; i++ {
+		val1, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		val2, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, val1, val2)
+	}
+
+	// 恢复的目标目录不为空
+	err = RestoreBackup(incrDir, restoreDir)
+	assert.Equal(t, ErrRestoreDirNotEmpty, err)
+}
+
+func TestDB_BackupIncremental_Corrupted(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-backup-corrupted")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	backupRoot, _ := os.MkdirTemp("", "fdb-go-backup-corrupted-dest")
+	defer func() {
+		_ = os.RemoveAll(backupRoot)
+	}()
+
+	for i := 0; i < 10; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	backupDir := filepath.Join(backupRoot, "full")
+	manifest, err := db.BackupIncremental(backupDir, "")
+	assert.Nil(t, err)
+
+	// 修改备份中的数据，恢复时校验失败
+	segmentName := backupSegmentName(manifest.Files[0].Name, 0)
+	err = os.WriteFile(filepath.Join(backupDir, segmentName), []byte("corrupted"), 0644)
+	assert.Nil(t, err)
+	err = RestoreBackup(backupDir, filepath.Join(backupRoot, "restore"))
+	assert.ErrorIs(t, err, ErrBackupCorrupted)
+}
+
+func TestDB_BackupSources_CopyAfterUnlock(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-backup-sources")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.CheckpointIndex()
+	assert.Nil(t, err)
+	checkpointFileName := filepath.Join(dir, data.IndexCheckpointFileName)
+	content, err := os.ReadFile(checkpointFileName)
+	assert.Nil(t, err)
+
+	sources, _, err := db.backupSources()
+	assert.Nil(t, err)
+	defer db.unpinBlobFiles()
+	defer closeBackupSources(sources)
+
+	// 确定备份的文件之后不再持有锁，可以继续写入，新的检查点替换原来的文件
+	for i := 100; i < 200; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.CheckpointIndex()
+	assert.Nil(t, err)
+	newContent, err := os.ReadFile(checkpointFileName)
+	assert.Nil(t, err)
+	assert.NotEqual(t, content, newContent)
+
+	// 拷贝到的依然是确定备份的文件时的内容
+	var found bool
+	for _, source := range sources {
+		if source.name != data.IndexCheckpointFileName {
+			continue
+		}
+		found = true
+		assert.NotNil(t, source.file)
+		buf := bytes.NewBuffer(nil)
+		err = writeBackupSource(buf, dir, source)
+		assert.Nil(t, err)
+		assert.Equal(t, content, buf.Bytes())
+	}
+	assert.True(t, found)
+}
+
+func TestDB_BackupIncremental_Relocated(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-backup-relocated")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	backupRoot, _ := os.MkdirTemp("", "fdb-go-backup-relocated-dest")
+	defer func() {
+		_ = os.RemoveAll(backupRoot)
+	}()
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	fullDir := filepath.Join(backupRoot, "full")
+	full, err := db.BackupIncremental(fullDir, "")
+	assert.Nil(t, err)
+
+	// 数据文件被原地重写成同样的大小，不能复用上一次备份的数据段
+	db.mu.Lock()
+	db.rewrittenFiles[0]++
+	db.mu.Unlock()
+	incrDir := filepath.Join(backupRoot, "incr")
+	incr, err := db.BackupIncremental(incrDir, fullDir)
+	assert.Nil(t, err)
+	assert.Equal(t, full.Files[0].Name, incr.Files[0].Name)
+	assert.Equal(t, full.Files[0].Size, incr.Files[0].Size)
+	assert.Equal(t, uint32(1), incr.Files[0].Generation)
+	assert.Len(t, incr.Files[0].Segments, 1)
+	assert.Equal(t, ".", incr.Files[0].Segments[0].Backup)
+
+	// 没有再被重写的文件复用上一次备份的数据段
+	incrDir2 := filepath.Join(backupRoot, "incr2")
+	incr2, err := db.BackupIncremental(incrDir2, incrDir)
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join("..", "incr"), incr2.Files[0].Segments[0].Backup)
+
+	// 整个备份链移动到其他位置之后依然可以恢复
+	movedRoot := backupRoot + "-moved"
+	err = os.Rename(backupRoot, movedRoot)
+	assert.Nil(t, err)
+	defer func() {
+		_ = os.RemoveAll(movedRoot)
+	}()
+	restoreDir := filepath.Join(movedRoot, "restore")
+	err = RestoreBackup(filepath.Join(movedRoot, "incr2"), restoreDir)
+	assert.Nil(t, err)
+	restoreOpts := opts
+	restoreOpts.DirPath = restoreDir
+	db2, err := Open(restoreOpts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(db2.ListKeys()))
+}
@@ -35,6 +35,9 @@ func (wb *WriteBatch) Put(key []byte, value []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if wb.db.options.ReadOnly {
+		return ErrReadOnly
+	}
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 	// 暂存logRecord
@@ -53,6 +56,9 @@ func (wb *WriteBatch) Delete(key []byte) error {
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if wb.db.options.ReadOnly {
+		return ErrReadOnly
+	}
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
@@ -87,24 +93,45 @@ func (wb *WriteBatch) Commit() error {
 		return ErrExceedMaxBatchNum
 	}
 
-	// 加锁，保证当前事务提交串行话
-	wb.db.mu.Lock()
-	defer wb.db.mu.Unlock()
+	// 加锁，保证当前事务提交串行话，需要持久化时和其他写入一起组提交
+	syncWrites := wb.options.SyncWrites || wb.db.options.SyncWrite
+	err := wb.db.commit(func() error {
+		return wb.db.writeTransaction(wb.pendingWrites)
+	}, syncWrites)
+	if err != nil {
+		return err
+	}
+
+	// 清空暂存数据
+	wb.pendingWrites = map[string]*data.LogRecord{}
+
+	return nil
+}
 
+// 以事务的方式将暂存的数据写到数据文件，并更新内存索引
+// 需要持久化时由 commit 在组提交中统一持久化，这里不持久化
+// 在访问此方法前必须持有互斥锁
+func (db *DB) writeTransaction(records map[string]*data.LogRecord) error {
 	// 获取当前最新的事务序列号
-	seqNo := atomic.AddUint64(&wb.db.seqNo, 1)
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
 
 	// 开始写数据到文件当中
 	positions := make(map[string]*data.LogRecordPos)
-	for _, record := range wb.pendingWrites {
-		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(record.Key, seqNo),
-			Value: record.Value,
-			Type:  record.Type,
+	for _, record := range records {
+		// value 较大时分离存储到 blob 文件中
+		logRecord, err := db.separateValue(record.Key, &data.LogRecord{
+			Key:    logRecordKeyWithSeq(record.Key, seqNo),
+			Value:  record.Value,
+			Type:   record.Type,
+			Expire: record.Expire,
 		})
 		if err != nil {
 			return err
 		}
+		logRecordPos, err := db.appendLogRecord(logRecord)
+		if err != nil {
+			return err
+		}
 		positions[string(record.Key)] = logRecordPos
 	}
 
@@ -113,34 +140,26 @@ func (wb *WriteBatch) Commit() error {
 		Key:  logRecordKeyWithSeq(txFinKey, seqNo),
 		Type: data.LogRecordTxFinished,
 	}
-	if _, err := wb.db.appendLogRecord(finishRecord); err != nil {
+	if _, err := db.appendLogRecord(finishRecord); err != nil {
 		return err
 	}
 
-	// 根据配置决定是否持久化数据
-	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
-			return err
-		}
-	}
-
-	// 更新内存索引
-	for _, record := range wb.pendingWrites {
+	// 更新内存索引，同一个事务产生的事件使用同一个写入序列号
+	writeSeqNo := atomic.AddUint64(&db.writeSeqNo, 1)
+	for _, record := range records {
 		pos := positions[string(record.Key)]
 		var oldPos *data.LogRecordPos
 		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
+			oldPos = db.indexPut(record.Key, pos)
 		} else if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
+			oldPos, _ = db.indexDelete(record.Key)
 		}
 		if oldPos != nil {
-			wb.db.reclaimSize += int64(oldPos.Size) // 增加无效数据大小，增加旧数据条目大小
+			db.reclaimPos(oldPos) // 增加无效数据大小，增加旧数据条目大小
 		}
+		db.notifyWatchers(record.Key, record.Value, record.Type == data.LogRecordDeleted, writeSeqNo)
 	}
 
-	// 清空暂存数据
-	wb.pendingWrites = map[string]*data.LogRecord{}
-
 	return nil
 }
 
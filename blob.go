@@ -0,0 +1,411 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/fio"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// 从磁盘中加载 blob 文件，并根据内存索引计算每个 blob 文件中的无效数据大小
+// 最后一个 blob 文件作为当前写入的 blob 文件继续追加写，保证 blob 文件ID不会被重复使用
+func (db *DB) loadBlobFiles() error {
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	var fileIds []int
+	for _, entry := range dirEntries {
+		if strings.HasSuffix(entry.Name(), data.BlobFileNameSuffix) {
+			fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.BlobFileNameSuffix))
+			if err != nil {
+				return ErrDataDirectoryCorrupted
+			}
+			fileIds = append(fileIds, fileId)
+		}
+	}
+	if len(fileIds) == 0 {
+		return nil
+	}
+	sort.Ints(fileIds)
+
+	fileSizes := make(map[uint32]int64, len(fileIds))
+	for i, fid := range fileIds {
+		blobFile, err := data.OpenBlobFile(db.options.DirPath, uint32(fid), db.fileIOType())
+		if err != nil {
+			return err
+		}
+		blobFile.Cipher = db.cipher
+		size, err := blobFile.IoManager.Size()
+		if err != nil {
+			return err
+		}
+		fileSizes[uint32(fid)] = size
+		if i == len(fileIds)-1 {
+			blobFile.WriteOff = size
+			db.activeBlobFile = blobFile
+		} else {
+			db.olderBlobFiles[uint32(fid)] = blobFile
+		}
+	}
+	db.nextBlobFid = uint32(fileIds[len(fileIds)-1]) + 1
+	atomic.StoreInt32(&db.blobFilesExist, 1)
+
+	// blob 文件的大小减去内存索引中仍然引用的数据大小，就是无效数据的大小
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if blob := iterator.Value().Blob; blob != nil {
+			fileSizes[blob.Fid] -= int64(blob.Size)
+		}
+	}
+	iterator.Close()
+	db.blobGarbage = fileSizes
+
+	return nil
+}
+
+// 如果 value 超过了配置的阀值，将其写入 blob 文件，返回只包含 blob 位置的记录，否则原样返回
+// 在访问此方法前必须持有互斥锁
+func (db *DB) separateValue(key []byte, logRecord *data.LogRecord) (*data.LogRecord, error) {
+	if db.options.ValueThreshold <= 0 || logRecord.Type != data.LogRecordNormal ||
+		len(logRecord.Value) <= db.options.ValueThreshold {
+		return logRecord, nil
+	}
+	blobPos, err := db.appendBlob(key, logRecord.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &data.LogRecord{
+		Key:    logRecord.Key,
+		Value:  data.EncodeLogRecordPos(blobPos),
+		Type:   data.LogRecordBlobRef,
+		Expire: logRecord.Expire,
+	}, nil
+}
+
+// 追加写 value 到当前的 blob 文件中，blob 文件中的记录同样会根据配置进行压缩和加密
+// 在访问此方法前必须持有互斥锁
+func (db *DB) appendBlob(key, value []byte) (*data.LogRecordPos, error) {
+	encRecord, size := db.encodeLogRecord(&data.LogRecord{
+		Key:   key,
+		Value: value,
+		Type:  data.LogRecordNormal,
+	})
+	// 当前没有 blob 文件，或者已经达到了文件大小的阀值，打开新的 blob 文件
+	if db.activeBlobFile == nil || db.activeBlobFile.WriteOff+size > db.options.DataFileSize {
+		if db.activeBlobFile != nil {
+			if err := db.activeBlobFile.Sync(); err != nil {
+				return nil, err
+			}
+			db.olderBlobFiles[db.activeBlobFile.FileId] = db.activeBlobFile
+		}
+		blobFile, err := db.openNewBlobFile()
+		if err != nil {
+			return nil, err
+		}
+		db.activeBlobFile = blobFile
+	}
+
+	writeOff := db.activeBlobFile.WriteOff
+	if err := db.activeBlobFile.Write(encRecord); err != nil {
+		return nil, err
+	}
+	atomic.AddUint64(&db.metrics.bytesWritten, uint64(size))
+	return &data.LogRecordPos{
+		Fid:    db.activeBlobFile.FileId,
+		Offset: writeOff,
+		Size:   uint32(size),
+	}, nil
+}
+
+// 使用下一个 blob 文件ID打开新的 blob 文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) openNewBlobFile() (*data.DataFile, error) {
+	blobFile, err := data.OpenBlobFile(db.options.DirPath, db.nextBlobFid, fio.StandardFIO)
+	if err != nil {
+		return nil, err
+	}
+	blobFile.Cipher = db.cipher
+	db.nextBlobFid++
+	atomic.StoreInt32(&db.blobFilesExist, 1)
+	return blobFile, nil
+}
+
+// 根据文件ID找到 blob 文件，包括已经被压缩但是仍然有读取者在使用的文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) blobFileById(fid uint32) *data.DataFile {
+	if db.activeBlobFile != nil && db.activeBlobFile.FileId == fid {
+		return db.activeBlobFile
+	}
+	if blobFile, ok := db.olderBlobFiles[fid]; ok {
+		return blobFile
+	}
+	return db.obsoleteBlobFiles[fid]
+}
+
+// 从 blob 文件中读取索引位置对应的value
+func getValueFromBlobFile(blobFile *data.DataFile, pos *data.LogRecordPos) ([]byte, error) {
+	if blobFile == nil {
+		return nil, ErrDataFileNotFound
+	}
+	logRecord, _, err := blobFile.ReadLogRecord(pos.Blob.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return logRecord.Value, nil
+}
+
+// 索引位置对应的数据已经无效，增加无效数据大小，value 分离存储时同时增加 blob 文件中的无效数据大小
+// 在访问此方法前必须持有互斥锁
+func (db *DB) reclaimPos(pos *data.LogRecordPos) {
+	db.addReclaimSize(pos.Fid, int64(pos.Size))
+	if pos.Blob != nil {
+		db.blobGarbage[pos.Blob.Fid] += int64(pos.Blob.Size)
+	}
+}
+
+// 持久化当前的 blob 文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) syncBlobFile() error {
+	if db.activeBlobFile == nil {
+		return nil
+	}
+	return db.activeBlobFile.Sync()
+}
+
+// 所有 blob 文件的大小
+// 在访问此方法前必须持有互斥锁
+func (db *DB) blobFilesSize() (int64, error) {
+	var totalSize int64
+	for _, blobFile := range db.allBlobFiles() {
+		size, err := blobFile.IoManager.Size()
+		if err != nil {
+			return 0, err
+		}
+		totalSize += size
+	}
+	return totalSize, nil
+}
+
+// 当前所有可以读取的 blob 文件，包括已经被压缩但是仍然有读取者在使用的文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) allBlobFiles() map[uint32]*data.DataFile {
+	blobFiles := make(map[uint32]*data.DataFile, len(db.olderBlobFiles)+len(db.obsoleteBlobFiles)+1)
+	for fid, blobFile := range db.obsoleteBlobFiles {
+		blobFiles[fid] = blobFile
+	}
+	for fid, blobFile := range db.olderBlobFiles {
+		blobFiles[fid] = blobFile
+	}
+	if db.activeBlobFile != nil {
+		blobFiles[db.activeBlobFile.FileId] = db.activeBlobFile
+	}
+	return blobFiles
+}
+
+// 快照、迭代器及备份在使用期间固定住当前的 blob 文件，被压缩的 blob 文件在所有读取者释放之后才会被关闭并删除
+func (db *DB) pinBlobFiles() {
+	atomic.AddInt32(&db.blobPins, 1)
+}
+
+// 释放固定的 blob 文件，不需要持有互斥锁，已经被压缩的 blob 文件留给之后的 CompactBlobs 或者 Close 清理
+func (db *DB) unpinBlobFiles() {
+	atomic.AddInt32(&db.blobPins, -1)
+}
+
+// 是否存在 blob 文件，没有的话迭代器不需要固定 blob 文件
+func (db *DB) hasBlobFiles() bool {
+	return atomic.LoadInt32(&db.blobFilesExist) == 1
+}
+
+// 没有读取者在使用时，关闭并删除已经被压缩的 blob 文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) removeObsoleteBlobFiles() error {
+	if atomic.LoadInt32(&db.blobPins) > 0 {
+		return nil
+	}
+	for fid, blobFile := range db.obsoleteBlobFiles {
+		if err := blobFile.Close(); err != nil {
+			return err
+		}
+		if err := os.Remove(data.GetBlobFileName(db.options.DirPath, fid)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(db.obsoleteBlobFiles, fid)
+	}
+	return nil
+}
+
+// 关闭所有的 blob 文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) closeBlobFiles() error {
+	atomic.StoreInt32(&db.blobPins, 0)
+	if err := db.removeObsoleteBlobFiles(); err != nil {
+		return err
+	}
+	for _, blobFile := range db.olderBlobFiles {
+		if err := blobFile.Close(); err != nil {
+			return err
+		}
+	}
+	if db.activeBlobFile != nil {
+		return db.activeBlobFile.Close()
+	}
+	return nil
+}
+
+// 需要从 blob 文件中搬移的数据
+type blobMove struct {
+	key    []byte
+	oldPos *data.LogRecordPos // 数据在旧的 blob 文件中的位置
+	newPos *data.LogRecordPos // 数据在新的 blob 文件中的位置
+}
+
+// CompactBlobs 压缩 blob 文件，回收被覆盖或删除的 value 占用的空间
+// 无效数据比例达到 BlobGarbageRatio 的 blob 文件中仍然有效的数据会被拷贝到新的 blob 文件中，
+// 并追加写入新的 blob 位置记录，之后旧的 blob 文件会被删除（仍在使用的快照、迭代器释放之后才会删除）
+// 拷贝数据时不持有锁，不阻塞读写
+func (db *DB) CompactBlobs() error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if !atomic.CompareAndSwapInt32(&db.blobCompacting, 0, 1) {
+		return ErrBlobCompactionIsProgress
+	}
+	defer atomic.StoreInt32(&db.blobCompacting, 0)
+
+	db.mu.Lock()
+
+	// 找出需要压缩的 blob 文件，当前写入的 blob 文件不参与压缩
+	candidates := make(map[uint32]*data.DataFile)
+	for fid, blobFile := range db.olderBlobFiles {
+		size, err := blobFile.IoManager.Size()
+		if err != nil {
+			db.mu.Unlock()
+			return err
+		}
+		if size == 0 || float32(db.blobGarbage[fid])/float32(size) >= db.options.BlobGarbageRatio {
+			candidates[fid] = blobFile
+		}
+	}
+	// 没有需要压缩的文件时，也要清理之前已经被压缩、读取者都已经释放的 blob 文件
+	if len(candidates) == 0 {
+		err := db.removeObsoleteBlobFiles()
+		db.mu.Unlock()
+		return err
+	}
+
+	// 根据内存索引找出这些文件中仍然有效的数据，并确定它们在新的 blob 文件中的位置
+	var moves []*blobMove
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		if pos.Blob != nil && candidates[pos.Blob.Fid] != nil {
+			moves = append(moves, &blobMove{key: append([]byte{}, iterator.Key()...), oldPos: pos.Blob})
+		}
+	}
+	iterator.Close()
+	sort.Slice(moves, func(i, j int) bool {
+		if moves[i].oldPos.Fid != moves[j].oldPos.Fid {
+			return moves[i].oldPos.Fid < moves[j].oldPos.Fid
+		}
+		return moves[i].oldPos.Offset < moves[j].oldPos.Offset
+	})
+	var outputFiles []*data.DataFile
+	var writeOff int64
+	for _, move := range moves {
+		if len(outputFiles) == 0 || writeOff+int64(move.oldPos.Size) > db.options.DataFileSize {
+			blobFile, err := db.openNewBlobFile()
+			if err != nil {
+				db.mu.Unlock()
+				return err
+			}
+			outputFiles = append(outputFiles, blobFile)
+			writeOff = 0
+		}
+		move.newPos = &data.LogRecordPos{
+			Fid:    outputFiles[len(outputFiles)-1].FileId,
+			Offset: writeOff,
+			Size:   move.oldPos.Size,
+		}
+		writeOff += int64(move.oldPos.Size)
+	}
+	db.mu.Unlock()
+
+	// 拷贝有效的数据到新的 blob 文件中，旧的 blob 文件不会再被写入，这里不需要持有锁
+	if err := copyBlobs(candidates, outputFiles, moves); err != nil {
+		// 拷贝失败，删除新的 blob 文件
+		for _, blobFile := range outputFiles {
+			_ = blobFile.Close()
+			_ = os.Remove(data.GetBlobFileName(db.options.DirPath, blobFile.FileId))
+		}
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, blobFile := range outputFiles {
+		db.olderBlobFiles[blobFile.FileId] = blobFile
+	}
+	// 拷贝期间没有被修改的key，写入新的 blob 位置，被修改的key拷贝的数据直接计入无效数据
+	for _, move := range moves {
+		pos := db.index.Get(move.key)
+		if pos == nil || pos.Blob == nil || pos.Blob.Fid != move.oldPos.Fid || pos.Blob.Offset != move.oldPos.Offset {
+			db.blobGarbage[move.newPos.Fid] += int64(move.newPos.Size)
+			continue
+		}
+		logRecord := &data.LogRecord{
+			Key:    logRecordKeyWithSeq(move.key, nonTransactionSeqNo),
+			Value:  data.EncodeLogRecordPos(move.newPos),
+			Type:   data.LogRecordBlobRef,
+			Expire: pos.Expire,
+		}
+		newPos, err := db.appendLogRecord(logRecord)
+		if err != nil {
+			return err
+		}
+		if oldPos := db.index.Put(move.key, newPos); oldPos != nil {
+			db.addReclaimSize(oldPos.Fid, int64(oldPos.Size))
+		}
+	}
+	// 新的 blob 位置持久化之后，才能删除旧的 blob 文件
+	if db.activeFile != nil {
+		if err := db.activeFile.Sync(); err != nil {
+			return err
+		}
+	}
+	for fid, blobFile := range candidates {
+		delete(db.olderBlobFiles, fid)
+		delete(db.blobGarbage, fid)
+		db.obsoleteBlobFiles[fid] = blobFile
+	}
+
+	return db.removeObsoleteBlobFiles()
+}
+
+// 将有效的数据原样拷贝到新的 blob 文件中
+func copyBlobs(candidates map[uint32]*data.DataFile, outputFiles []*data.DataFile, moves []*blobMove) error {
+	outputs := make(map[uint32]*data.DataFile, len(outputFiles))
+	for _, blobFile := range outputFiles {
+		outputs[blobFile.FileId] = blobFile
+	}
+	for _, move := range moves {
+		buf := make([]byte, move.oldPos.Size)
+		if _, err := candidates[move.oldPos.Fid].IoManager.Read(buf, move.oldPos.Offset); err != nil {
+			return err
+		}
+		if err := outputs[move.newPos.Fid].Write(buf); err != nil {
+			return err
+		}
+	}
+	for _, blobFile := range outputFiles {
+		if err := blobFile.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_ValueThreshold(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-value-threshold")
+	opts.DirPath = dir
+	opts.ValueThreshold = 128
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.小的value依然写在数据文件中，大的value写入 blob 文件
+	smallValue := utils.RandomValue(64)
+	largeValue := utils.RandomValue(4096)
+	err = db.Put(utils.GetTestKey(1), smallValue)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), largeValue)
+	assert.Nil(t, err)
+	assert.Nil(t, db.index.Get(utils.GetTestKey(1)).Blob)
+	assert.NotNil(t, db.index.Get(utils.GetTestKey(2)).Blob)
+	assert.Less(t, db.activeFile.WriteOff, int64(1024))
+	assert.Equal(t, uint(1), db.Stat().BlobFileNum)
+
+	val, err := db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, largeValue, val)
+
+	// 2.批量写入同样会分离存储
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 10; i < 20; i++ {
+		err := wb.Put(utils.GetTestKey(i), largeValue)
+		assert.Nil(t, err)
+	}
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	// 3.覆盖和删除之后计入 blob 文件的无效数据
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(4096))
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Greater(t, db.Stat().BlobReclaimSize, int64(2*4096))
+
+	// 4.重启之后依然可以读取，并重新计算无效数据
+	stat := db.Stat()
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	assert.Equal(t, stat.BlobReclaimSize, db2.Stat().BlobReclaimSize)
+	val, err = db2.Get(utils.GetTestKey(15))
+	assert.Nil(t, err)
+	assert.Equal(t, largeValue, val)
+	val, err = db2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, smallValue, val)
+	_, err = db2.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_CompactBlobs(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-compact-blobs")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.ValueThreshold = 128
+	opts.BlobGarbageRatio = 0.3
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 100; i++ {
+		values[i] = utils.RandomValue(2048)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	// 删除一半的数据
+	for i := 0; i < 100; i += 2 {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(values, i)
+	}
+	stat := db.Stat()
+	assert.Greater(t, stat.BlobFileNum, uint(2))
+
+	// 快照中的数据在压缩之后依然可以读取
+	snap := db.Snapshot()
+	iter := db.NewIterator(DefaultIteratorOptions)
+
+	err = db.CompactBlobs()
+	assert.Nil(t, err)
+	newStat := db.Stat()
+	assert.Less(t, newStat.BlobReclaimSize, stat.BlobReclaimSize)
+	assert.Less(t, newStat.BlobFileNum, stat.BlobFileNum)
+	for i, value := range values {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	val, err := snap.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, values[1], val)
+	iter.Rewind()
+	val, err = iter.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, values[1], val)
+
+	// 快照和迭代器释放之后，下一次压缩时删除旧的 blob 文件
+	blobFile := data.GetBlobFileName(opts.DirPath, 0)
+	_, err = os.Stat(blobFile)
+	assert.Nil(t, err)
+	snap.Release()
+	iter.Close()
+	err = db.CompactBlobs()
+	assert.Nil(t, err)
+	_, err = os.Stat(blobFile)
+	assert.True(t, os.IsNotExist(err))
+
+	// merge 之后重启，数据依然可以读取
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	assert.Equal(t, 50, len(db2.ListKeys()))
+	for i, value := range values {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+}
@@ -0,0 +1,292 @@
+package fdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/fio"
+	"github.com/calmw/fdb/index"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	indexCheckpointKey    = "index.checkpoint"
+	indexCheckpointEndKey = "index.checkpoint.end"
+	indexCheckpointTmpExt = ".tmp"
+)
+
+// 索引检查点的元信息，记录检查点覆盖到的日志位置
+// 检查点文件格式和 hint 文件一样，第一条记录是元信息，之后是 count 条索引记录，最后是结束标识记录
+// 每条记录都有 CRC 校验，结束标识用于确认检查点文件写入完整
+type indexCheckpointMeta struct {
+	pos         LogPosition      // 在这个位置之前写入的数据都已经包含在检查点中
+	seqNo       uint64           // 事务序列号
+	reclaimSize int64            // 无效数据大小
+	count       uint64           // 索引记录的数量
+	fileGarbage map[uint32]int64 // 每个数据文件中无效数据的大小
+}
+
+// CheckpointIndex 将内存索引持久化为索引检查点，重启时只需要重放检查点之后写入的数据
+// B+树索引本身就是持久化的，不需要检查点
+func (db *DB) CheckpointIndex() error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if db.options.IndexType == IndexTypeBPlusTree {
+		return nil
+	}
+	db.checkpointMu.Lock()
+	defer db.checkpointMu.Unlock()
+	// 读锁可以阻止写入，保证索引和检查点位置一致
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.writeIndexCheckpoint()
+}
+
+// 将内存索引写到临时文件中，写入完成之后再替换掉旧的检查点
+// 在访问此方法前必须持有互斥锁（或者持有读锁以及 checkpointMu）
+func (db *DB) writeIndexCheckpoint() error {
+	if db.activeFile == nil {
+		return nil
+	}
+	// 检查点覆盖的数据必须已经持久化，否则重启之后索引可能指向不存在的数据
+	if err := db.syncBlobFile(); err != nil {
+		return err
+	}
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
+
+	fileName := filepath.Join(db.options.DirPath, data.IndexCheckpointFileName)
+	tmpFileName := fileName + indexCheckpointTmpExt
+	file, err := os.OpenFile(tmpFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(tmpFileName)
+	}()
+
+	writer := bufio.NewWriter(file)
+	meta := &indexCheckpointMeta{
+		pos:         LogPosition{Fid: db.activeFile.FileId, Offset: db.activeFile.WriteOff},
+		seqNo:       db.seqNo,
+		reclaimSize: db.reclaimSize,
+		count:       uint64(db.index.Size()),
+		fileGarbage: db.fileGarbage,
+	}
+	if err = db.writeCheckpointRecord(writer, []byte(indexCheckpointKey), encodeIndexCheckpointMeta(meta)); err != nil {
+		return err
+	}
+
+	var count uint64
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if err = db.writeCheckpointRecord(writer, iterator.Key(), data.EncodeLogRecordPos(iterator.Value())); err != nil {
+			iterator.Close()
+			return err
+		}
+		count++
+	}
+	iterator.Close()
+	if count != meta.count {
+		return ErrDataDirectoryCorrupted
+	}
+
+	if err = db.writeCheckpointRecord(writer, []byte(indexCheckpointEndKey), binary.AppendUvarint(nil, count)); err != nil {
+		return err
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
+// 检查点文件可能很大，编码之后通过缓冲写入，避免每条记录一次系统调用
+func (db *DB) writeCheckpointRecord(w io.Writer, key, value []byte) error {
+	logRecord := &data.LogRecord{Key: key, Value: value}
+	if db.cipher != nil {
+		logRecord = data.EncryptLogRecord(logRecord, db.cipher)
+	}
+	encRecord, _ := data.EncodeLogRecord(logRecord)
+	_, err := w.Write(encRecord)
+	return err
+}
+
+// 从索引检查点加载索引，返回检查点覆盖到的位置，之后的数据需要从数据文件中重放
+// 检查点不存在或者无效时返回 false，此时内存索引保持为空，需要从 hint 文件及数据文件全量加载
+func (db *DB) loadIndexCheckpoint() (LogPosition, bool) {
+	fileName := filepath.Join(db.options.DirPath, data.IndexCheckpointFileName)
+	if _, err := os.Stat(fileName); err != nil {
+		return LogPosition{}, false
+	}
+	pos, err := db.readIndexCheckpoint()
+	if err == nil {
+		return pos, true
+	}
+
+	// 检查点无效，丢弃已经加载的部分索引，并删除检查点文件
+	_ = db.index.Close()
+	db.index = index.NewIndexer(db.options.IndexType, db.options.DirPath, db.options.SyncWrite)
+	db.reclaimSize = 0
+	db.fileGarbage = make(map[uint32]int64)
+	db.seqNo = nonTransactionSeqNo
+	if !db.options.ReadOnly {
+		_ = os.Remove(fileName)
+	}
+	return LogPosition{}, false
+}
+
+func (db *DB) readIndexCheckpoint() (LogPosition, error) {
+	checkpointFile, err := data.OpenIndexCheckpointFile(db.options.DirPath, db.startupIOType())
+	if err != nil {
+		return LogPosition{}, err
+	}
+	defer func() {
+		_ = checkpointFile.Close()
+	}()
+	checkpointFile.Cipher = db.cipher
+
+	record, offset, err := checkpointFile.ReadLogRecord(0)
+	if err != nil {
+		return LogPosition{}, err
+	}
+	meta, ok := decodeIndexCheckpointMeta(record)
+	if !ok {
+		return LogPosition{}, ErrDataDirectoryCorrupted
+	}
+
+	// 检查点覆盖的数据文件必须存在且没有被截断，merge 之后旧的检查点会被删除
+	dataFile := db.activeFile
+	if dataFile == nil || meta.pos.Fid != dataFile.FileId {
+		dataFile = db.olderFiles[meta.pos.Fid]
+	}
+	if dataFile == nil {
+		return LogPosition{}, ErrDataDirectoryCorrupted
+	}
+	size, err := dataFile.IoManager.Size()
+	if err != nil {
+		return LogPosition{}, err
+	}
+	if size < meta.pos.Offset {
+		return LogPosition{}, ErrDataDirectoryCorrupted
+	}
+
+	now := time.Now().UnixNano()
+	for i := uint64(0); i < meta.count; i++ {
+		record, size, err := checkpointFile.ReadLogRecord(offset)
+		if err != nil {
+			return LogPosition{}, err
+		}
+		logRecordPos := data.DecodeLogRecordPos(record.Value)
+		if logRecordPos.IsExpired(now) { // 已经过期的key不再加载，计入无效数据大小
+			db.reclaimPos(logRecordPos)
+		} else {
+			db.index.Put(record.Key, logRecordPos)
+		}
+		offset += size
+	}
+
+	// 校验结束标识，确认检查点文件是完整的
+	record, _, err = checkpointFile.ReadLogRecord(offset)
+	if err != nil {
+		return LogPosition{}, err
+	}
+	count, n := binary.Uvarint(record.Value)
+	if !bytes.Equal(record.Key, []byte(indexCheckpointEndKey)) || n <= 0 || count != meta.count {
+		return LogPosition{}, ErrDataDirectoryCorrupted
+	}
+
+	db.seqNo = meta.seqNo
+	db.reclaimSize += meta.reclaimSize
+	for fid, size := range meta.fileGarbage {
+		db.fileGarbage[fid] += size
+	}
+	return meta.pos, nil
+}
+
+// 删除索引检查点，merge 替换数据文件之后检查点中的位置不再有效
+func removeIndexCheckpoint(dirPath string) error {
+	fileName := filepath.Join(dirPath, data.IndexCheckpointFileName)
+	if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func encodeIndexCheckpointMeta(meta *indexCheckpointMeta) []byte {
+	buf := make([]byte, 0, binary.MaxVarintLen32+binary.MaxVarintLen64*5+
+		len(meta.fileGarbage)*(binary.MaxVarintLen32+binary.MaxVarintLen64))
+	buf = binary.AppendUvarint(buf, uint64(meta.pos.Fid))
+	buf = binary.AppendVarint(buf, meta.pos.Offset)
+	buf = binary.AppendUvarint(buf, meta.seqNo)
+	buf = binary.AppendVarint(buf, meta.reclaimSize)
+	buf = binary.AppendUvarint(buf, meta.count)
+	buf = binary.AppendUvarint(buf, uint64(len(meta.fileGarbage)))
+	for fid, size := range meta.fileGarbage {
+		buf = binary.AppendUvarint(buf, uint64(fid))
+		buf = binary.AppendVarint(buf, size)
+	}
+	return buf
+}
+
+func decodeIndexCheckpointMeta(record *data.LogRecord) (*indexCheckpointMeta, bool) {
+	if !bytes.Equal(record.Key, []byte(indexCheckpointKey)) {
+		return nil, false
+	}
+	buf := record.Value
+	meta := &indexCheckpointMeta{}
+	fid, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, false
+	}
+	meta.pos.Fid = uint32(fid)
+	buf = buf[n:]
+	if meta.pos.Offset, n = binary.Varint(buf); n <= 0 {
+		return nil, false
+	}
+	buf = buf[n:]
+	if meta.seqNo, n = binary.Uvarint(buf); n <= 0 {
+		return nil, false
+	}
+	buf = buf[n:]
+	if meta.reclaimSize, n = binary.Varint(buf); n <= 0 {
+		return nil, false
+	}
+	buf = buf[n:]
+	if meta.count, n = binary.Uvarint(buf); n <= 0 {
+		return nil, false
+	}
+	buf = buf[n:]
+	fileNum, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return nil, false
+	}
+	buf = buf[n:]
+	meta.fileGarbage = make(map[uint32]int64)
+	for i := uint64(0); i < fileNum; i++ {
+		fid, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, false
+		}
+		buf = buf[n:]
+		size, n := binary.Varint(buf)
+		if n <= 0 {
+			return nil, false
+		}
+		buf = buf[n:]
+		meta.fileGarbage[uint32(fid)] = size
+	}
+	return meta, true
+}
@@ -0,0 +1,86 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_CheckpointIndex(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-checkpoint-index")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.CheckpointIndex()
+	assert.Nil(t, err)
+	checkpointFileName := filepath.Join(dir, data.IndexCheckpointFileName)
+	checkpoint, err := os.ReadFile(checkpointFileName)
+	assert.Nil(t, err)
+
+	// 检查点之后继续写入，包括事务和范围删除
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 1000; i < 1100; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = wb.Commit()
+	assert.Nil(t, err)
+	err = db.DeleteRange(utils.GetTestKey(100), utils.GetTestKey(200))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(500), []byte("new value"))
+	assert.Nil(t, err)
+	stat := db.Stat()
+	seqNo := db.seqNo
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 1.使用旧的检查点，重启时重放检查点之后的数据
+	err = os.WriteFile(checkpointFileName, checkpoint, 0644)
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, stat.KeyNum, db2.Stat().KeyNum)
+	reclaimSize := db2.Stat().ReclaimSize
+	assert.Equal(t, seqNo, db2.seqNo)
+	_, err = db2.Get(utils.GetTestKey(150))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), val)
+	_, err = db2.Get(utils.GetTestKey(1050))
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// 2.检查点损坏时回退到全量加载，和从检查点加载的结果一致
+	checkpoint, err = os.ReadFile(checkpointFileName)
+	assert.Nil(t, err)
+	err = os.WriteFile(checkpointFileName, checkpoint[:len(checkpoint)/2], 0644)
+	assert.Nil(t, err)
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db3.Close()
+	}()
+	assert.Equal(t, stat.KeyNum, db3.Stat().KeyNum)
+	assert.Equal(t, reclaimSize, db3.Stat().ReclaimSize)
+	val, err = db3.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), val)
+}
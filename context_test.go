@@ -0,0 +1,176 @@
+package fdb
+
+import (
+	"context"
+	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// 检查 n 次之后被取消的 context
+type countdownContext struct {
+	context.Context
+	n int32
+}
+
+func (ctx *countdownContext) Err() error {
+	if atomic.AddInt32(&ctx.n, -1) < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestDB_MergeContext(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-merge-context")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 1.已经取消的 context 不会开始 merge
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = db.MergeContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+
+	// 2.重写数据文件的过程中取消，merge 目录被删除
+	err = db.MergeContext(&countdownContext{Context: context.Background(), n: 100})
+	assert.Equal(t, context.Canceled, err)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	// 取消之后可以再次 merge
+	err = db.MergeContext(context.Background())
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(db.getMergePath(), data.MergeFinishedFileName))
+	assert.Nil(t, err)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Equal(t, uint(500), db2.Stat().KeyNum)
+}
+
+func TestDB_FoldContext(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-fold-context")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var count int
+	err = db.FoldContext(ctx, func(key []byte, value []byte) bool {
+		count++
+		if count == 10 {
+			cancel()
+		}
+		return true
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 10, count)
+
+	count = 0
+	err = db.FoldContext(context.Background(), func(key []byte, value []byte) bool {
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 100, count)
+}
+
+func TestDB_BackupContext(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-backup-context")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	// 取消时删除这次备份创建的目录
+	backupDir, _ := os.MkdirTemp("", "fdb-go-backup-context-dest")
+	defer func() {
+		_ = os.RemoveAll(backupDir)
+	}()
+	dest := filepath.Join(backupDir, "backup")
+	err = db.BackupContext(&countdownContext{Context: context.Background(), n: 5}, dest)
+	assert.Equal(t, context.Canceled, err)
+	_, err = os.Stat(dest)
+	assert.True(t, os.IsNotExist(err))
+
+	err = db.BackupContext(context.Background(), dest)
+	assert.Nil(t, err)
+	opts.DirPath = dest
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Equal(t, uint(1000), db2.Stat().KeyNum)
+}
+
+func TestOpenContext(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-open-context")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+	// 删除索引检查点，打开时需要从数据文件中加载索引
+	_ = os.Remove(filepath.Join(dir, data.IndexCheckpointFileName))
+
+	// 加载索引的过程中取消，释放文件锁之后可以再次打开
+	_, err = OpenContext(&countdownContext{Context: context.Background(), n: 100}, opts)
+	assert.Equal(t, context.Canceled, err)
+
+	db2, err := OpenContext(context.Background(), opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Equal(t, uint(1000), db2.Stat().KeyNum)
+}
@@ -0,0 +1,100 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	ErrDecryptFailed          = errors.New("failed to decrypt log record, the encryption key maybe wrong")
+	ErrEncryptionKeyRequired  = errors.New("log record is encrypted, but no encryption key is provided")
+	ErrInvalidEncryptedRecord = errors.New("invalid encrypted log record")
+)
+
+// Cipher 使用 AES-GCM 对数据进行加解密
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher 根据密钥初始化 Cipher，密钥长度必须是 16、24 或 32 字节，分别对应 AES-128、AES-192、AES-256
+func NewCipher(key []byte) (*Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Seal 加密数据，随机生成的 nonce 存放在密文的前面，additionalData 不加密但是参与认证，解密时需要传入相同的数据
+func (c *Cipher) Seal(plaintext, additionalData []byte) []byte {
+	nonce := make([]byte, c.aead.NonceSize(), c.sealedSize(len(plaintext)))
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		panic("failed to generate nonce")
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, additionalData)
+}
+
+// Open 解密数据，additionalData 和加密时传入的不一致时解密失败
+func (c *Cipher) Open(sealed, additionalData []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, ErrDecryptFailed
+	}
+	plaintext, err := c.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], additionalData)
+	if err != nil {
+		return nil, ErrDecryptFailed
+	}
+	return plaintext, nil
+}
+
+// 长度为 n 的明文加密之后的长度
+func (c *Cipher) sealedSize(n int) int {
+	return c.aead.NonceSize() + n + c.aead.Overhead()
+}
+
+// EncryptLogRecord 加密 LogRecord 的 key 和 value
+// 加密之后的记录 key 为空，value 存储的是 keySize + key + value 的密文
+// type、过期时间等头部信息不加密，但是作为附加数据参与认证，被篡改之后无法解密
+func EncryptLogRecord(logRecord *LogRecord, c *Cipher) *LogRecord {
+	plaintext := make([]byte, binary.MaxVarintLen32+len(logRecord.Key)+len(logRecord.Value))
+	var index = binary.PutUvarint(plaintext, uint64(len(logRecord.Key)))
+	index += copy(plaintext[index:], logRecord.Key)
+	index += copy(plaintext[index:], logRecord.Value)
+
+	encRecord := &LogRecord{
+		Type:       logRecord.Type,
+		Expire:     logRecord.Expire,
+		Compressed: logRecord.Compressed,
+		Encrypted:  true,
+	}
+	header := encodeLogRecordHeader(encRecord, 0, c.sealedSize(index))
+	encRecord.Value = c.Seal(plaintext[:index], header)
+	return encRecord
+}
+
+// 解密 LogRecord，还原出 key 和 value，header 是记录中 crc 之后的头部信息
+func decryptLogRecord(logRecord *LogRecord, c *Cipher, header []byte) error {
+	if c == nil {
+		return ErrEncryptionKeyRequired
+	}
+	plaintext, err := c.Open(logRecord.Value, header)
+	if err != nil {
+		return err
+	}
+	keySize, n := binary.Uvarint(plaintext)
+	if n <= 0 || uint64(len(plaintext)-n) < keySize {
+		return ErrInvalidEncryptedRecord
+	}
+	logRecord.Key = plaintext[n : n+int(keySize)]
+	logRecord.Value = plaintext[n+int(keySize):]
+	logRecord.Encrypted = false
+	return nil
+}
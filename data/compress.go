@@ -0,0 +1,32 @@
+package data
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+// CompressValue 使用 flate 压缩 value
+func CompressValue(value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(value); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecompressValue 解压使用 flate 压缩的 value
+func DecompressValue(value []byte) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(value))
+	defer func() {
+		_ = reader.Close()
+	}()
+	return io.ReadAll(reader)
+}
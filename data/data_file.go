@@ -10,7 +10,8 @@ import (
 )
 
 var (
-	ErrInvalidCRC = errors.New("invalid crc value, log record maybe corrupted")
+	ErrInvalidCRC              = errors.New("invalid crc value, log record maybe corrupted")
+	ErrInvalidCompressedRecord = errors.New("failed to decompress the value of log record")
 )
 
 // DataFile 数据文件
@@ -18,13 +19,18 @@ type DataFile struct {
 	FileId    uint32        // 文件ID
 	WriteOff  int64         // 文件写到了哪个位置
 	IoManager fio.IOManager // 读写管理
+	Cipher    *Cipher       // 加解密，为空表示不加密
 }
 
 const (
-	DataFileNameSuffix    = ".data"          // 定义数据文件后缀
-	HintFileName          = "hint-index"     // 定义hint文件名称
-	MergeFinishedFileName = "merge-finished" // 定义merge完成的文件名称
-	SeqNoFileName         = "seq-no"         // 存储事务序列号的文件，用于B+树
+	DataFileNameSuffix      = ".data"            // 定义数据文件后缀
+	BlobFileNameSuffix      = ".blob"            // 定义 value 分离存储的 blob 文件后缀
+	HintFileName            = "hint-index"       // 定义hint文件名称
+	MergeFinishedFileName   = "merge-finished"   // 定义merge完成的文件名称
+	SeqNoFileName           = "seq-no"           // 存储事务序列号的文件，用于B+树
+	KeyCheckFileName        = "key-check"        // 用于校验加密密钥是否正确的文件
+	IndexCheckpointFileName = "index-checkpoint" // 关闭数据库时持久化的内存索引快照
+	RewrittenFilesFileName  = "rewritten-files"  // 被 MergeFiles、Repair 原地重写过的数据文件及其版本
 )
 
 // OpenDataFile 打开新的数据文件
@@ -33,28 +39,56 @@ func OpenDataFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFi
 	return newDataFile(fileName, fileId, ioType)
 }
 
+// OpenBlobFile 打开 value 分离存储的 blob 文件
+func OpenBlobFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+	fileName := GetBlobFileName(dirPath, fileId)
+	return newDataFile(fileName, fileId, ioType)
+}
+
 // OpenHintFile 打开Hint索引文件
-func OpenHintFile(dirPath string) (*DataFile, error) {
+func OpenHintFile(dirPath string, ioType fio.FileIOType) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, HintFileName)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+	return newDataFile(fileName, 0, ioType)
 }
 
 // OpenSeqNoFile 存储事务序列号的文件
-func OpenSeqNoFile(dirPath string) (*DataFile, error) {
+func OpenSeqNoFile(dirPath string, ioType fio.FileIOType) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, SeqNoFileName)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+	return newDataFile(fileName, 0, ioType)
+}
+
+// OpenKeyCheckFile 打开校验加密密钥的文件
+func OpenKeyCheckFile(dirPath string, ioType fio.FileIOType) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, KeyCheckFileName)
+	return newDataFile(fileName, 0, ioType)
+}
+
+// OpenIndexCheckpointFile 打开索引检查点文件，只在启动时读取，可以使用内存文件映射加快读取
+func OpenIndexCheckpointFile(dirPath string, ioType fio.FileIOType) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, IndexCheckpointFileName)
+	return newDataFile(fileName, 0, ioType)
+}
+
+// OpenRewrittenFilesFile 打开记录原地重写过的数据文件及其版本的文件
+func OpenRewrittenFilesFile(dirPath string, ioType fio.FileIOType) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, RewrittenFilesFileName)
+	return newDataFile(fileName, 0, ioType)
 }
 
 // OpenMergeFinishedFile 打开标识merge完成的文件
-func OpenMergeFinishedFile(dirPath string) (*DataFile, error) {
+func OpenMergeFinishedFile(dirPath string, ioType fio.FileIOType) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, MergeFinishedFileName)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+	return newDataFile(fileName, 0, ioType)
 }
 
 func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
 
+func GetBlobFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+BlobFileNameSuffix)
+}
+
 func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
 	// 初始化IO管理器接口
 	ioManager, err := fio.NewIOManager(fileName, ioType)
@@ -99,8 +133,13 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	// 取出对应的key和value的长度
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
 	var recordSize = headerSize + keySize + valueSize
+	// 记录超出了文件的长度，说明数据不完整（或者 header 已经损坏），避免按照错误的长度分配内存
+	if offset+recordSize > fileSize {
+		return nil, 0, io.EOF
+	}
 	logRecord := &LogRecord{
-		Type: header.recordType,
+		Type:   header.recordType,
+		Expire: header.expire,
 	}
 	// 开始读取用户实际存储的key/value数据
 	if keySize > 0 || valueSize > 0 {
@@ -117,10 +156,53 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 	if crc != header.crc {
 		return nil, 0, ErrInvalidCRC
 	}
+	// 解密 key 和 value
+	if header.encrypted {
+		if err := decryptLogRecord(logRecord, df.Cipher, headerBuf[crc32.Size:headerSize]); err != nil {
+			return nil, 0, err
+		}
+	}
+	// 解压 value，返回的 recordSize 依然是磁盘上的大小
+	if header.compressed {
+		value, err := DecompressValue(logRecord.Value)
+		if err != nil {
+			return nil, 0, ErrInvalidCompressedRecord
+		}
+		logRecord.Value = value
+	}
 
 	return logRecord, recordSize, nil
 }
 
+// ReadLogRecordSize 只根据 header 计算 offset 处记录的大小，不校验数据，可以用来跳过损坏的记录
+// header 中的大小超出了文件的长度时返回 io.ErrUnexpectedEOF
+func (df *DataFile) ReadLogRecordSize(offset int64) (int64, error) {
+	fileSize, err := df.IoManager.Size()
+	if err != nil {
+		return 0, err
+	}
+	var headerBytes int64 = maxLogRecordHeaderSize
+	if offset+maxLogRecordHeaderSize > fileSize {
+		headerBytes = fileSize - offset
+	}
+	if headerBytes <= 0 {
+		return 0, io.EOF
+	}
+	headerBuf, err := df.readNBytes(headerBytes, offset)
+	if err != nil {
+		return 0, err
+	}
+	header, headerSize := decodeLogRecordHeader(headerBuf)
+	if header == nil || header.crc == 0 && header.keySize == 0 && header.valueSize == 0 {
+		return 0, io.EOF
+	}
+	recordSize := headerSize + int64(header.keySize) + int64(header.valueSize)
+	if headerSize <= 5 || offset+recordSize > fileSize {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return recordSize, nil
+}
+
 func (df *DataFile) Write(buf []byte) error {
 	n, err := df.IoManager.Write(buf)
 	if err != nil {
@@ -130,15 +212,24 @@ func (df *DataFile) Write(buf []byte) error {
 	return nil
 }
 
+// WriteLogRecord 编码并写入 LogRecord，设置了 Cipher 时会先进行加密
+func (df *DataFile) WriteLogRecord(logRecord *LogRecord) error {
+	if df.Cipher != nil {
+		logRecord = EncryptLogRecord(logRecord, df.Cipher)
+	}
+	encRecord, _ := EncodeLogRecord(logRecord)
+
+	return df.Write(encRecord)
+}
+
 // WriteHintRecord 写入索引信息到hint文件中
 func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 	record := &LogRecord{
 		Key:   key,
 		Value: EncodeLogRecordPos(pos),
 	}
-	encRecord, _ := EncodeLogRecord(record)
 
-	return df.Write(encRecord)
+	return df.WriteLogRecord(record)
 }
 
 func (df *DataFile) Close() error {
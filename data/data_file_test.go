@@ -1,6 +1,7 @@
 package data
 
 import (
+	"bytes"
 	"github.com/calmw/fdb/fio"
 	"github.com/stretchr/testify/assert"
 	"os"
@@ -54,3 +55,120 @@ func TestDataFile_Sync(t *testing.T) {
 	err = dataFile.Sync()
 	assert.Nil(t, err)
 }
+
+func TestDataFile_ReadLogRecord(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "fdb-go-data-file")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	assert.NotNil(t, dataFile)
+
+	// 普通记录
+	rec1 := &LogRecord{Key: []byte("name"), Value: []byte("fdb"), Type: LogRecordNormal}
+	enc1, size1 := EncodeLogRecord(rec1)
+	err = dataFile.Write(enc1)
+	assert.Nil(t, err)
+
+	// 带过期时间的记录
+	rec2 := &LogRecord{Key: []byte("session"), Value: []byte("token"), Type: LogRecordNormal, Expire: 1700000000000000000}
+	enc2, size2 := EncodeLogRecord(rec2)
+	err = dataFile.Write(enc2)
+	assert.Nil(t, err)
+
+	readRec1, readSize1, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, size1, readSize1)
+	assert.Equal(t, rec1, readRec1)
+
+	readRec2, readSize2, err := dataFile.ReadLogRecord(size1)
+	assert.Nil(t, err)
+	assert.Equal(t, size2, readSize2)
+	assert.Equal(t, rec2, readRec2)
+}
+
+func TestDataFile_ReadLogRecord_Compressed(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "fdb-go-data-file-compressed")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	assert.NotNil(t, dataFile)
+
+	value := bytes.Repeat([]byte("fdb-compress-"), 100)
+	compressed, err := CompressValue(value)
+	assert.Nil(t, err)
+	assert.Less(t, len(compressed), len(value))
+
+	rec := &LogRecord{Key: []byte("doc"), Value: compressed, Type: LogRecordNormal, Compressed: true}
+	enc, size := EncodeLogRecord(rec)
+	err = dataFile.Write(enc)
+	assert.Nil(t, err)
+
+	// 读取时自动解压，大小依然是磁盘上的大小
+	readRec, readSize, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, size, readSize)
+	assert.Equal(t, value, readRec.Value)
+	assert.False(t, readRec.Compressed)
+}
+
+func TestDataFile_ReadLogRecord_Encrypted(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "fdb-go-data-file-encrypted")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	cipher, err := NewCipher([]byte("0123456789abcdef"))
+	assert.Nil(t, err)
+	dataFile.Cipher = cipher
+
+	rec := &LogRecord{Key: []byte("name"), Value: []byte("fdb"), Type: LogRecordNormal, Expire: 1700000000000000000}
+	err = dataFile.WriteLogRecord(rec)
+	assert.Nil(t, err)
+
+	readRec, _, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec, readRec)
+
+	// 密钥错误或者没有密钥都无法读取
+	wrongCipher, err := NewCipher([]byte("fedcba9876543210"))
+	assert.Nil(t, err)
+	dataFile.Cipher = wrongCipher
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, ErrDecryptFailed, err)
+	dataFile.Cipher = nil
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, ErrEncryptionKeyRequired, err)
+}
+
+func TestDataFile_ReadEncryptedLogRecord(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "fdb-go-data-file-encrypted")
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO)
+	assert.Nil(t, err)
+	c, err := NewCipher([]byte("0123456789abcdef"))
+	assert.Nil(t, err)
+	dataFile.Cipher = c
+
+	rec := &LogRecord{Key: []byte("session"), Value: []byte("token"), Type: LogRecordNormal, Expire: 1700000000000000000}
+	err = dataFile.WriteLogRecord(rec)
+	assert.Nil(t, err)
+	readRec, size, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec, readRec)
+
+	// 篡改 header 中的过期时间并重新计算 crc，header 参与了认证，无法解密
+	encRecord := EncryptLogRecord(rec, c)
+	encRecord.Expire++
+	enc, _ := EncodeLogRecord(encRecord)
+	err = dataFile.Write(enc)
+	assert.Nil(t, err)
+	_, _, err = dataFile.ReadLogRecord(size)
+	assert.Equal(t, ErrDecryptFailed, err)
+}
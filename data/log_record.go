@@ -8,18 +8,31 @@ import (
 type LogRecordType = byte
 
 const (
-	LogRecordNormal     LogRecordType = iota // 普通类型
-	LogRecordDeleted                         // 删除类型
-	LogRecordTxFinished                      // 事务类型
+	LogRecordNormal       LogRecordType = iota // 普通类型
+	LogRecordDeleted                           // 删除类型
+	LogRecordTxFinished                        // 事务类型
+	LogRecordRangeDeleted                      // 范围删除类型，key 为区间起点，value 为区间终点（不包含），value 为空表示没有终点
+	LogRecordBlobRef                           // value 分离存储的类型，value 中存储的是实际数据在 blob 文件中的位置
 )
 
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5 // 4+1+5+5
+// type 字节的高位用作标志位，低位存储实际的 LogRecordType，旧的数据文件中标志位均为0，可以正常读取
+const (
+	logRecordExpireFlag   byte = 1 << 7 // header 中带有过期时间
+	logRecordCompressFlag byte = 1 << 6 // value 经过了 flate 压缩
+	logRecordEncryptFlag  byte = 1 << 5 // key 和 value 经过了加密
+	logRecordFlagMask          = logRecordExpireFlag | logRecordCompressFlag | logRecordEncryptFlag
+)
+
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + binary.MaxVarintLen64 + 5 // 4+1+5+5+10
 
 // LogRecord 写入到数据文件的记录，之所以叫日志，是因为数据文件中的数据是追加写的，类似日志格式
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key        []byte
+	Value      []byte
+	Type       LogRecordType
+	Expire     int64 // 过期时间，UnixNano，0表示永不过期
+	Compressed bool  // value 是否经过压缩，读取时会自动解压，所以读出的记录该字段始终为false
+	Encrypted  bool  // key 和 value 是否经过加密，读取时会自动解密，所以读出的记录该字段始终为false
 }
 
 // LogRecordHeader LogRecord 的头部信息
@@ -28,13 +41,23 @@ type LogRecordHeader struct {
 	recordType LogRecordType //标识logRecord的类型
 	keySize    uint32        // key的长度
 	valueSize  uint32        // value的长度
+	expire     int64         // 过期时间，UnixNano，0表示永不过期
+	compressed bool          // value 是否经过压缩
+	encrypted  bool          // key 和 value 是否经过加密
 }
 
 // LogRecordPos 数据内存索引，主要是描述数据在磁盘上的位置
 type LogRecordPos struct {
-	Fid    uint32 // 文件ID，表示将数据存储在哪个文件中
-	Offset int64  // 偏移量，表示将数据存储到了文件中的哪个位置
-	Size   uint32 // 数据在磁盘上的大小
+	Fid    uint32        // 文件ID，表示将数据存储在哪个文件中
+	Offset int64         // 偏移量，表示将数据存储到了文件中的哪个位置
+	Size   uint32        // 数据在磁盘上的大小
+	Expire int64         // 过期时间，UnixNano，0表示永不过期
+	Blob   *LogRecordPos // value 分离存储时，实际数据在 blob 文件中的位置，否则为空
+}
+
+// IsExpired 判断数据在给定时间（UnixNano）是否已经过期
+func (pos *LogRecordPos) IsExpired(now int64) bool {
+	return pos.Expire > 0 && pos.Expire <= now
 }
 
 // TransactionRecord 暂存的事务相关的数据
@@ -45,25 +68,21 @@ type TransactionRecord struct {
 
 // EncodeLogRecord 对 LogRecord 进行编码，返回字节数组及长度
 //
-//	+-------------+-------------+-------------+--------------+-------------+--------------+
-//	| crc 校验值  |  type 类型   |    key size |   value size |      key    |      value   |
-//	+-------------+-------------+-------------+--------------+-------------+--------------+
-//	    4字节          1字节        变长（最大5）   变长（最大5）     变长           变长
+//	+-------------+-------------+-------------+--------------+---------------+-------------+--------------+
+//	| crc 校验值  |  type 类型   |    key size |   value size | expire(可选)   |      key    |      value   |
+//	+-------------+-------------+-------------+--------------+---------------+-------------+--------------+
+//	    4字节          1字节        变长（最大5）   变长（最大5）   变长（最大10）      变长           变长
+//
+// 只有设置了过期时间的记录才会写入 expire 字段，并在 type 字节中置上 logRecordExpireFlag 标志位
+// value 经过压缩的记录在 type 字节中置上 logRecordCompressFlag 标志位，加密的记录置上 logRecordEncryptFlag 标志位
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
-	// 初始化一个header部分的字节数组
-	header := make([]byte, maxLogRecordHeaderSize)
-
-	// 第五个字节存储Type
-	header[4] = logRecord.Type
-	var index = 5
-	// 5字节之后，存储的是key和value的长度信息
-	// 使用变长类型，节省空间
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Key)))
-	index += binary.PutVarint(header[index:], int64(len(logRecord.Value)))
+	// header部分，前4个字节留给crc
+	header := encodeLogRecordHeader(logRecord, len(logRecord.Key), len(logRecord.Value))
+	var index = crc32.Size + len(header)
 	var size = index + len(logRecord.Key) + len(logRecord.Value)
 	encBytes := make([]byte, size)
 	// 将header部分的内容拷贝过来
-	copy(encBytes[:index], header[:index])
+	copy(encBytes[crc32.Size:index], header)
 	// 将key和value的数据拷贝到字节数组中
 	copy(encBytes[index:], logRecord.Key)
 	copy(encBytes[index+len(logRecord.Key):], logRecord.Value)
@@ -75,6 +94,33 @@ func EncodeLogRecord(logRecord *LogRecord) ([]byte, int64) {
 	return encBytes, int64(size)
 }
 
+// 编码 header 中 crc 之后的部分：type 及标志位、key size、value size 以及可选的过期时间
+// 加密时这部分作为 GCM 的附加数据参与认证，所以需要在加密之前根据密文的长度单独编码
+func encodeLogRecordHeader(logRecord *LogRecord, keySize, valueSize int) []byte {
+	header := make([]byte, maxLogRecordHeaderSize-crc32.Size)
+
+	// 第一个字节存储Type及标志位
+	header[0] = logRecord.Type
+	if logRecord.Expire > 0 {
+		header[0] |= logRecordExpireFlag
+	}
+	if logRecord.Compressed {
+		header[0] |= logRecordCompressFlag
+	}
+	if logRecord.Encrypted {
+		header[0] |= logRecordEncryptFlag
+	}
+	var index = 1
+	// 之后存储的是key和value的长度信息
+	// 使用变长类型，节省空间
+	index += binary.PutVarint(header[index:], int64(keySize))
+	index += binary.PutVarint(header[index:], int64(valueSize))
+	if logRecord.Expire > 0 {
+		index += binary.PutVarint(header[index:], logRecord.Expire)
+	}
+	return header[:index]
+}
+
 // 对LogRecord header进行解码,拿到头部信息
 func decodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
 	if len(buf) <= 4 {
@@ -82,7 +128,9 @@ func decodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
 	}
 	header := &LogRecordHeader{
 		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		recordType: buf[4],
+		recordType: buf[4] &^ logRecordFlagMask,
+		compressed: buf[4]&logRecordCompressFlag != 0,
+		encrypted:  buf[4]&logRecordEncryptFlag != 0,
 	}
 	var index = 5
 	// 取出实际的key size
@@ -93,6 +141,12 @@ func decodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
 	valueSize, n := binary.Varint(buf[index:])
 	header.valueSize = uint32(valueSize)
 	index += n
+	// 取出过期时间
+	if buf[4]&logRecordExpireFlag != 0 {
+		expire, n := binary.Varint(buf[index:])
+		header.expire = expire
+		index += n
+	}
 
 	return header, int64(index)
 }
@@ -109,12 +163,21 @@ func getLogRecordCRC(lr *LogRecord, header []byte) uint32 {
 }
 
 // EncodeLogRecordPos 对logRecordPos(位置信息)进行编码
+// 过期时间和 blob 位置都是可选的，带有 blob 位置时即使没有过期时间也会写入0，保证 blob 位置可以被正确解析
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	buf := make([]byte, binary.MaxVarintLen32*4+binary.MaxVarintLen64*3)
 	var index = 0
 	index += binary.PutVarint(buf[index:], int64(pos.Fid))
 	index += binary.PutVarint(buf[index:], pos.Offset)
 	index += binary.PutVarint(buf[index:], int64(pos.Size))
+	if pos.Expire > 0 || pos.Blob != nil {
+		index += binary.PutVarint(buf[index:], pos.Expire)
+	}
+	if pos.Blob != nil {
+		index += binary.PutVarint(buf[index:], int64(pos.Blob.Fid))
+		index += binary.PutVarint(buf[index:], pos.Blob.Offset)
+		index += binary.PutVarint(buf[index:], int64(pos.Blob.Size))
+	}
 	return buf[:index]
 }
 
@@ -125,11 +188,30 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	index += n
 	offset, n := binary.Varint(buf[index:])
 	index += n
-	size, _ := binary.Varint(buf[index:])
+	size, n := binary.Varint(buf[index:])
+	index += n
+	// 旧版本编码的位置信息中没有过期时间，此时解码得到0
+	expire, n := binary.Varint(buf[index:])
+	index += n
 
-	return &LogRecordPos{
+	pos := &LogRecordPos{
 		Fid:    uint32(fid),
 		Offset: offset,
 		Size:   uint32(size),
+		Expire: expire,
+	}
+	// 后面还有数据，说明带有 blob 位置
+	if index < len(buf) {
+		blobFid, n := binary.Varint(buf[index:])
+		index += n
+		blobOffset, n := binary.Varint(buf[index:])
+		index += n
+		blobSize, _ := binary.Varint(buf[index:])
+		pos.Blob = &LogRecordPos{
+			Fid:    uint32(blobFid),
+			Offset: blobOffset,
+			Size:   uint32(blobSize),
+		}
 	}
+	return pos
 }
@@ -32,3 +32,15 @@ func Test_decodeLogRecordHeader(t *testing.T) {
 func Test_getLogRecordCRC(t *testing.T) {
 
 }
+
+func TestEncodeLogRecordPos(t *testing.T) {
+	pos1 := &LogRecordPos{Fid: 1, Offset: 100, Size: 20}
+	assert.Equal(t, pos1, DecodeLogRecordPos(EncodeLogRecordPos(pos1)))
+
+	pos2 := &LogRecordPos{Fid: 1, Offset: 100, Size: 20, Expire: 1700000000000000000}
+	assert.Equal(t, pos2, DecodeLogRecordPos(EncodeLogRecordPos(pos2)))
+
+	// 带有 blob 位置
+	pos3 := &LogRecordPos{Fid: 1, Offset: 100, Size: 20, Blob: &LogRecordPos{Fid: 3, Offset: 4096, Size: 1024}}
+	assert.Equal(t, pos3, DecodeLogRecordPos(EncodeLogRecordPos(pos3)))
+}
@@ -1,6 +1,8 @@
 package fdb
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/calmw/fdb/data"
@@ -16,6 +18,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // DB 存储引擎实例
@@ -27,12 +31,48 @@ type DB struct {
 	olderFiles      map[uint32]*data.DataFile // 旧的数据文件，只用于读
 	index           index.Indexer             // 内存索引
 	seqNo           uint64                    // 事务序列号
+	writeSeqNo      uint64                    // 写入序列号，每次写入递增，只保存在内存中，作为事件的序列号
 	isMerging       bool                      // 是否正在merge
 	seqNoFileExists bool                      // 存储事务序列号的seqNo文件是否存在
 	isInitial       bool                      // 是否初始化数据目录，第一次启动
 	fileLock        *flock.Flock              // 文件锁，保证多进程之间（基于同一数据库文件目录的进程）互斥
 	bytesWrite      uint                      // 当前累计写了多少字节
 	reclaimSize     int64                     // 表示有多少数据是无效的
+	fileGarbage     map[uint32]int64          // 每个数据文件中无效数据的大小
+	rewrittenFiles  map[uint32]uint32         // 被 MergeFiles、Repair 原地重写过的数据文件的版本，用于判断日志位置是否有效
+	cipher          *data.Cipher              // 数据加密，未配置密钥时为空
+	watchMu         *sync.RWMutex             // 保护 watchers
+	watchers        map[*Watcher]struct{}     // 订阅key变更的观察者
+	checkpointMu    *sync.Mutex               // 保证同一时间只有一个索引检查点在写入
+	autoMergeStop   chan struct{}             // 通知后台自动 merge 的协程退出
+	autoMergeDone   chan struct{}             // 后台自动 merge 的协程已经退出
+	truncatedTail   *TruncatedTail            // 启动时从最新的数据文件末尾截断的损坏数据
+	commitMu        *sync.Mutex               // 保护 commitQueue
+	commitQueue     []*commitRequest          // 等待组提交的写入请求，队首的请求是 leader
+	syncDeferred    bool                      // 组提交时由 leader 统一持久化，写入时不需要持久化
+	commitUndos     []*indexUndo              // 组提交期间对内存索引的修改，持久化失败时回滚
+	commitEvents    []*WatchEvent             // 组提交期间产生的事件，持久化成功之后才发送
+	lastSyncTime    time.Time                 // 最近一次成功持久化活跃文件的时间
+	autoSyncStop    chan struct{}             // 通知后台定时持久化的协程退出
+	autoSyncDone    chan struct{}             // 后台定时持久化的协程已经退出
+
+	followStop       chan struct{}                        // 通知后台跟随写入进程的协程退出
+	followDone       chan struct{}                        // 后台跟随写入进程的协程已经退出
+	followFileInfo   os.FileInfo                          // 只读模式下正在跟随的活跃文件，用于发现文件被 merge 替换
+	followTxnRecords map[uint64][]*data.TransactionRecord // 只读模式下还没有读到完成标识的事务数据
+	retiredFiles     []*data.DataFile                     // 只读模式下重新加载之前的文件，关闭数据库时才关闭
+
+	metrics  *metrics // 运行指标
+	listener Listener // 事件监听器，没有配置时为 NopListener
+
+	activeBlobFile    *data.DataFile            // 当前写入的 blob 文件
+	olderBlobFiles    map[uint32]*data.DataFile // 旧的 blob 文件，只用于读
+	obsoleteBlobFiles map[uint32]*data.DataFile // 已经被压缩，等待读取者释放之后删除的 blob 文件
+	blobGarbage       map[uint32]int64          // 每个 blob 文件中无效数据的大小
+	nextBlobFid       uint32                    // 下一个 blob 文件的ID
+	blobPins          int32                     // 固定住 blob 文件的快照、迭代器及备份的数量
+	blobFilesExist    int32                     // 是否打开过 blob 文件，原子操作读写，迭代器据此判断是否需要固定 blob 文件
+	blobCompacting    int32                     // 是否正在压缩 blob 文件
 }
 
 // Stat 存储引擎统计信息
@@ -41,23 +81,42 @@ type Stat struct {
 	DataFileNum uint  // 数据文件的数量
 	ReclaimSize int64 // 可以进行merge回收的数据量，字节为单位
 	DiskSize    int64 // 数据目录所占磁盘空间大小
+
+	DataFileReclaimSize map[uint32]int64 // 每个数据文件中可以回收的数据量，字节为单位
+
+	BlobFileNum     uint  // blob 文件的数量
+	BlobReclaimSize int64 // blob 文件中可以通过 CompactBlobs 回收的数据量，字节为单位
+
+	LastSyncTime time.Time // 最近一次成功持久化活跃文件的时间，还没有持久化过时为零值
 }
 
 const (
-	seqNoKey   = "seq.no"
-	dbFileLock = "db.flock"
+	seqNoKey    = "seq.no"
+	dbFileLock  = "db.flock"
+	keyCheckKey = "key.check"
 )
 
+var keyCheckValue = []byte("fdb") // 写入校验密钥文件中的明文
+
 // Open 打开存储引擎实例
 func Open(options Options) (*DB, error) {
+	return OpenContext(context.Background(), options)
+}
+
+// OpenContext 打开存储引擎实例，ctx 取消时停止加载索引，释放已经打开的文件以及文件锁并返回 ctx.Err()
+// 取消之前已经生效的 merge 结果以及截断的损坏数据不会恢复，不影响之后再次打开
+func OpenContext(ctx context.Context, options Options) (_ *DB, err error) {
 	// 对用户输入的配置文件进行校验
 	if err := checkOptions(options); err != nil {
 		return nil, err
 	}
 
 	var isInitial bool
-	// 判断数据目录是否存在，如果不存在，则创建这个目录
+	// 判断数据目录是否存在，如果不存在，则创建这个目录，只读模式不创建
 	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+		if options.ReadOnly {
+			return nil, err
+		}
 		isInitial = true
 		if err = os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
 			return nil, err
@@ -65,15 +124,33 @@ func Open(options Options) (*DB, error) {
 	}
 
 	// 判断当前目录是否正在使用，单进程使用
+	// 只读模式不获取文件锁，fileLock 没有加锁时 Unlock 什么也不做
 	fileLock := flock.New(path.Join(options.DirPath, dbFileLock))
-	hold, err := fileLock.TryLock()
-	if err != nil {
-		return nil, err
-	}
-	if !hold { // 有其他进程在使用
-		return nil, ErrDatabaseIsUsing
+	if !options.ReadOnly {
+		hold, err := fileLock.TryLock()
+		if err != nil {
+			return nil, err
+		}
+		if !hold { // 有其他进程在使用
+			return nil, ErrDatabaseIsUsing
+		}
 	}
 
+	// 打开失败时关闭索引和已经打开的文件并释放文件锁，避免之后无法再次打开
+	var db *DB
+	defer func() {
+		if err != nil {
+			if db != nil {
+				_ = db.index.Close()
+				if db.activeFile != nil {
+					_ = db.closeFiles()
+				}
+				_ = db.closeBlobFiles()
+			}
+			_ = fileLock.Unlock()
+		}
+	}()
+
 	entries, err := os.ReadDir(options.DirPath)
 	if err != nil {
 		return nil, err
@@ -83,18 +160,45 @@ func Open(options Options) (*DB, error) {
 	}
 
 	// 初始化DB实例结构
-	db := &DB{
+	db = &DB{
 		options: options,
 		mu:      &sync.RWMutex{},
 		//activeFile: nil,
-		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrite),
-		isInitial:  isInitial,
-		fileLock:   fileLock,
+		olderFiles:   make(map[uint32]*data.DataFile),
+		index:        index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrite),
+		isInitial:    isInitial,
+		fileLock:     fileLock,
+		watchMu:      &sync.RWMutex{},
+		watchers:     make(map[*Watcher]struct{}),
+		checkpointMu: &sync.Mutex{},
+		commitMu:     &sync.Mutex{},
+		metrics:      newMetrics(),
+		listener:     options.Listener,
+		fileGarbage:  make(map[uint32]int64),
+
+		olderBlobFiles:    make(map[uint32]*data.DataFile),
+		obsoleteBlobFiles: make(map[uint32]*data.DataFile),
+		blobGarbage:       make(map[uint32]int64),
+	}
+	if db.listener == nil {
+		db.listener = NopListener{}
+	}
+
+	// 校验加密密钥
+	if err = db.loadCipher(); err != nil {
+		return nil, err
 	}
 
 	// 加载merge数据目录,将merge后的数据文件和索引文件移动到了数据目录下
-	if err = db.loadMergeFiles(); err != nil {
+	// 只读模式不移动文件，merge 生效之前数据目录中的文件仍然是完整的
+	if !options.ReadOnly {
+		if err = db.loadMergeFiles(); err != nil {
+			return nil, err
+		}
+	}
+
+	// 加载原地重写过的数据文件的版本，需要在 merge 的结果生效之后加载
+	if db.rewrittenFiles, err = loadRewrittenFiles(options.DirPath, db.cipher); err != nil {
 		return nil, err
 	}
 
@@ -105,13 +209,16 @@ func Open(options Options) (*DB, error) {
 
 	// B+树索引不需要从数据文件中加载索引
 	if db.options.IndexType != IndexTypeBPlusTree {
-		// 从hint索引文件加载索引
-		if err := db.loadIndexFromHintFile(); err != nil {
-			return nil, err
+		// 优先从索引检查点加载索引，检查点无效时从hint索引文件加载索引
+		from, ok := db.loadIndexCheckpoint()
+		if !ok {
+			if err := db.loadIndexFromHintFile(ctx); err != nil {
+				return nil, err
+			}
 		}
 
-		// 从数据文件中加载索引
-		if err := db.loadIndexFromDataFiles(); err != nil {
+		// 从数据文件中加载索引，只需要重放检查点之后写入的数据
+		if err := db.loadIndexFromDataFiles(ctx, from); err != nil {
 			return nil, err
 		}
 
@@ -137,6 +244,29 @@ func Open(options Options) (*DB, error) {
 		}
 	}
 
+	// 加载 value 分离存储的 blob 文件，需要在索引加载完成之后计算无效数据
+	if err = db.loadBlobFiles(); err != nil {
+		return nil, err
+	}
+
+	// 启动后台自动 merge
+	if options.AutoMerge.Enable && !options.ReadOnly {
+		db.startAutoMerge()
+	}
+	// 启动后台定时持久化
+	if options.SyncInterval > 0 && !options.ReadOnly {
+		db.startAutoSync()
+	}
+	// 只读模式下跟随写入的进程
+	if options.ReadOnly {
+		if err = db.followActiveFile(); err != nil {
+			return nil, err
+		}
+		if options.Follow.Interval > 0 {
+			db.startFollow()
+		}
+	}
+
 	return db, nil
 }
 
@@ -147,34 +277,75 @@ func (db *DB) Close() error {
 			panic(fmt.Sprintf("failed to unlock th dorectory, %v", err))
 		}
 	}()
+	// 停止后台自动 merge，需要在持有锁之前等待正在进行的 merge 完成
+	db.stopAutoMerge()
+	db.stopAutoSync()
+	db.stopFollow()
+	// 关闭所有的观察者
+	db.closeWatchers()
+
 	if db.activeFile == nil {
 		return nil
 	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	// 只读模式只需要关闭文件
+	if db.options.ReadOnly {
+		for _, file := range db.retiredFiles {
+			if err := file.Close(); err != nil {
+				return err
+			}
+		}
+		if err := db.closeBlobFiles(); err != nil {
+			return err
+		}
+		if err := db.index.Close(); err != nil {
+			return err
+		}
+		return db.closeFiles()
+	}
+
+	// 持久化内存索引，下次启动时不需要重放全部数据文件
+	if db.options.IndexType != IndexTypeBPlusTree {
+		if err := db.writeIndexCheckpoint(); err != nil {
+			return err
+		}
+	}
+
+	// 关闭 blob 文件
+	if err := db.closeBlobFiles(); err != nil {
+		return err
+	}
+
 	// 关闭索引，特别是B+树是需要关闭的，毕竟它本是是个数据库实例
 	if err := db.index.Close(); err != nil {
 		return err
 	}
 
 	// 保存当前事务序列号
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
+	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath, fio.StandardFIO)
 	if err != nil {
 		return err
 	}
+	seqNoFile.Cipher = db.cipher
 	record := &data.LogRecord{
 		Key:   []byte(seqNoKey),
 		Value: []byte(strconv.FormatUint(db.seqNo, 10)),
 	}
-	encRecord, _ := data.EncodeLogRecord(record)
-	if err = seqNoFile.Write(encRecord); err != nil {
+	if err = seqNoFile.WriteLogRecord(record); err != nil {
 		return err
 	}
 	if err = seqNoFile.Sync(); err != nil {
 		return err
 	}
 
+	return db.closeFiles()
+}
+
+// 关闭当前活跃文件和旧的数据文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) closeFiles() error {
 	// 关闭当前活跃文件
 	if err := db.activeFile.Close(); err != nil {
 		return err
@@ -191,18 +362,19 @@ func (db *DB) Close() error {
 
 // Sync 持久化数据文件
 func (db *DB) Sync() error {
-	if db.activeFile == nil {
+	if db.activeFile == nil || db.options.ReadOnly {
 		return nil
 	}
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	return db.activeFile.Sync()
+	return db.syncActiveFile()
 }
 
-// Stat 返回数据库的相关统计信息
+// Stat 返回数据库的相关统计信息，只读取计数，不会修改内存索引
+// 已经过期但是还没有被清理的key依然计入 KeyNum，过期的key在被读取、Merge 或者 PickMergeFiles 清理之后，其占用的空间才计入 ReclaimSize
 func (db *DB) Stat() *Stat {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 
 	dataFiles := uint(len(db.olderFiles))
 	dirSize, err := utils.DirSize(db.options.DirPath)
@@ -212,59 +384,133 @@ func (db *DB) Stat() *Stat {
 	if db.activeFile != nil {
 		dataFiles += 1
 	}
+	blobFiles := uint(len(db.olderBlobFiles))
+	if db.activeBlobFile != nil {
+		blobFiles += 1
+	}
+	var blobReclaimSize int64
+	for _, size := range db.blobGarbage {
+		blobReclaimSize += size
+	}
+	fileReclaimSize := make(map[uint32]int64, len(db.fileGarbage))
+	for fid, size := range db.fileGarbage {
+		fileReclaimSize[fid] = size
+	}
 	return &Stat{
-		KeyNum:      uint(db.index.Size()),
-		DataFileNum: dataFiles,
-		ReclaimSize: db.reclaimSize,
-		DiskSize:    dirSize,
+		KeyNum:              uint(db.index.Size()),
+		DataFileNum:         dataFiles,
+		ReclaimSize:         db.reclaimSize,
+		DiskSize:            dirSize,
+		DataFileReclaimSize: fileReclaimSize,
+		BlobFileNum:         blobFiles,
+		BlobReclaimSize:     blobReclaimSize,
+		LastSyncTime:        db.lastSyncTime,
 	}
 }
 
 // Backup 备份数据库，将数据文件拷贝，排除锁文件
 func (db *DB) Backup(dir string) error {
+	return db.BackupContext(context.Background(), dir)
+}
+
+// BackupContext 备份数据库，ctx 取消时停止拷贝并返回 ctx.Err()
+// 失败时如果备份目录是这次备份创建的，则删除备份目录，避免留下不完整的备份
+func (db *DB) BackupContext(ctx context.Context, dir string) (err error) {
+	if _, statErr := os.Stat(dir); os.IsNotExist(statErr) {
+		defer func() {
+			if err != nil {
+				_ = os.RemoveAll(dir)
+			}
+		}()
+	}
+
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-	return utils.CopyDir(db.options.DirPath, dir, []string{dbFileLock})
+	return utils.CopyDirContext(ctx, db.options.DirPath, dir, []string{dbFileLock})
 }
 
 // Put 写入key/value数据
 func (db *DB) Put(key, value []byte) error {
+	return db.put(key, value, 0)
+}
+
+// PutWithTTL 写入key/value数据，并设置过期时间，ttl小于等于0表示永不过期
+// 过期的key对 Get、Fold、ListKeys、Iterator 均不可见，并在 merge 时被清理
+func (db *DB) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
+	}
+	return db.put(key, value, expire)
+}
+
+func (db *DB) put(key, value []byte, expire int64) error {
+	defer db.metrics.observePut(time.Now())
 	// 检查key
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	return db.commit(func() error {
+		return db.putWithoutLock(key, value, expire)
+	}, db.options.SyncWrite)
+}
+
+// 写入key/value数据并更新内存索引
+// 在访问此方法前必须持有互斥锁
+func (db *DB) putWithoutLock(key, value []byte, expire int64) error {
 	logRecord := &data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:    logRecordKeyWithSeq(key, nonTransactionSeqNo),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: expire,
+	}
+	// value 较大时分离存储到 blob 文件中
+	logRecord, err := db.separateValue(key, logRecord)
+	if err != nil {
+		return err
 	}
 
 	// 追加写入到当前文件
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
 
 	// 更新内存索引
-	if oldPos := db.index.Put(key, pos); oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size) // key之前已经存在，增加无效数据大小
+	if oldPos := db.indexPut(key, pos); oldPos != nil {
+		db.reclaimPos(oldPos) // key之前已经存在，增加无效数据大小
 	}
+	db.notifyWatchers(key, value, false, atomic.AddUint64(&db.writeSeqNo, 1))
 
 	return nil
 }
 
 // Get 根据key读取数据
 func (db *DB) Get(key []byte) ([]byte, error) {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
+	defer db.metrics.observeGet(time.Now())
 	// 检查key
 	if len(key) == 0 {
 		return nil, ErrKeyIsEmpty
 	}
+	db.mu.RLock()
+	value, err := db.getWithoutLock(key)
+	expired := err == ErrKeyNotFound && db.index.Get(key) != nil
+	db.mu.RUnlock()
+
+	// 读取到已经过期的key时将其从内存索引中删除，只读模式下不修改内存索引
+	if expired && !db.options.ReadOnly {
+		db.removeExpiredKey(key)
+	}
+	return value, err
+}
+
+// 根据key读取数据
+// 在访问此方法前必须持有互斥锁
+func (db *DB) getWithoutLock(key []byte) ([]byte, error) {
 	// 从内存数据结构中取出key对应的索引信息
 	logRecordPos := db.index.Get(key)
-	// 如果key不在内存索引中,说明key不存在
-	if logRecordPos == nil {
+	// 如果key不在内存索引中或者已经过期,说明key不存在
+	if logRecordPos == nil || logRecordPos.IsExpired(time.Now().UnixNano()) {
 		return nil, ErrKeyNotFound
 	}
 
@@ -274,12 +520,24 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 
 // Fold 获取所有的数据，并执行用户指定的操作,函数返回false时终止遍历
 func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
+	return db.FoldContext(context.Background(), fn)
+}
+
+// FoldContext 和 Fold 相同，ctx 取消时停止遍历并返回 ctx.Err()
+func (db *DB) FoldContext(ctx context.Context, fn func(key []byte, value []byte) bool) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
 	iterator := db.index.Iterator(false)
 	defer iterator.Close()
+	now := time.Now().UnixNano()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
 		val, err := db.getValueByPosition(iterator.Value())
 		if err != nil {
 			return err
@@ -296,17 +554,25 @@ func (db *DB) ListKeys() [][]byte {
 	iterator := db.index.Iterator(false)
 	defer iterator.Close() // B+树的迭代器，读写事务是互斥的，读完，不关闭的话，写不进去，btree和amt其实不需要关闭迭代器
 
-	keys := make([][]byte, db.index.Size())
-	idx := 0
+	keys := make([][]byte, 0, db.index.Size())
+	now := time.Now().UnixNano()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
+		keys = append(keys, iterator.Key())
 	}
 	return keys
 }
 
 // 根据索引信息获取对应的value
 func (db *DB) getValueByPosition(pos *data.LogRecordPos) ([]byte, error) {
+	// value 分离存储，直接从 blob 文件中读取
+	if pos.Blob != nil {
+		atomic.AddUint64(&db.metrics.bytesRead, uint64(pos.Blob.Size))
+		return getValueFromBlobFile(db.blobFileById(pos.Blob.Fid), pos)
+	}
+	atomic.AddUint64(&db.metrics.bytesRead, uint64(pos.Size))
 	// 根据文件ID找到数据文件
 	var dataFile *data.DataFile
 	if db.activeFile.FileId == pos.Fid {
@@ -314,6 +580,11 @@ func (db *DB) getValueByPosition(pos *data.LogRecordPos) ([]byte, error) {
 	} else {
 		dataFile = db.olderFiles[pos.Fid]
 	}
+	return getValueFromDataFile(dataFile, pos)
+}
+
+// 从指定的数据文件中读取索引位置对应的value
+func getValueFromDataFile(dataFile *data.DataFile, pos *data.LogRecordPos) ([]byte, error) {
 	// 数据文件为空
 	if dataFile == nil {
 		return nil, ErrDataFileNotFound
@@ -327,15 +598,60 @@ func (db *DB) getValueByPosition(pos *data.LogRecordPos) ([]byte, error) {
 	if logRecord.Type == data.LogRecordDeleted {
 		return nil, ErrKeyNotFound
 	}
+	if logRecord.Expire > 0 && logRecord.Expire <= time.Now().UnixNano() {
+		return nil, ErrKeyNotFound
+	}
 	return logRecord.Value, nil
 }
 
+// 将已经过期的key从内存索引中删除，并计入无效数据大小
+func (db *DB) removeExpiredKey(key []byte) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// 释放读锁之后key可能已经被重新写入，需要再次检查
+	if pos := db.index.Get(key); pos == nil || !pos.IsExpired(time.Now().UnixNano()) {
+		return
+	}
+	if oldPos, _ := db.index.Delete(key); oldPos != nil {
+		db.reclaimPos(oldPos)
+	}
+}
+
+// 将内存索引中已经过期的key删除，并计入无效数据大小
+// 在访问此方法前必须持有互斥锁
+func (db *DB) removeExpiredKeys() {
+	var expiredKeys [][]byte
+	now := time.Now().UnixNano()
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if iterator.Value().IsExpired(now) {
+			expiredKeys = append(expiredKeys, iterator.Key())
+		}
+	}
+	iterator.Close()
+
+	for _, key := range expiredKeys {
+		if oldPos, _ := db.index.Delete(key); oldPos != nil {
+			db.reclaimPos(oldPos)
+		}
+	}
+}
+
 // Delete 根据key删除数据
 func (db *DB) Delete(key []byte) error {
+	defer db.metrics.observeDelete(time.Now())
 	// 检查key
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	return db.commit(func() error {
+		return db.deleteWithoutLock(key)
+	}, db.options.SyncWrite)
+}
+
+// 删除key并更新内存索引
+// 在访问此方法前必须持有互斥锁
+func (db *DB) deleteWithoutLock(key []byte) error {
 	// 从内存数据结构中取出key对应的索引信息
 	pos := db.index.Get(key)
 	// 如果key不在内存索引中,说明key不存在,直接返回
@@ -348,19 +664,20 @@ func (db *DB) Delete(key []byte) error {
 		Type: data.LogRecordDeleted,
 	}
 	// 写入数据文件中
-	pos, err := db.appendLogRecordWithLock(logRecord)
+	pos, err := db.appendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
-	db.reclaimSize += int64(pos.Size) // 成功删除，增加无效数据大小， 增加删除标识的数据条目大小
+	db.reclaimPos(pos) // 成功删除，增加无效数据大小， 增加删除标识的数据条目大小
 	// 从内存索引中，将对应的key删除
-	oldPos, ok := db.index.Delete(key)
+	oldPos, ok := db.indexDelete(key)
 	if !ok {
 		return ErrIndexUpdateFailed
 	}
 	if oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size) // 成功删除，增加无效数据大小，增加旧数据条目大小
+		db.reclaimPos(oldPos) // 成功删除，增加无效数据大小，增加旧数据条目大小
 	}
+	db.notifyWatchers(key, nil, true, atomic.AddUint64(&db.writeSeqNo, 1))
 
 	return nil
 }
@@ -377,6 +694,7 @@ func (db *DB) setActiveDataFile() error {
 	if err != nil {
 		return err
 	}
+	dataFile.Cipher = db.cipher
 	db.activeFile = dataFile
 	return nil
 }
@@ -405,14 +723,11 @@ func (db *DB) loadDataFiles() error {
 	db.fileIds = fileIds
 	// 遍历每个文件ID，打开对应的数据文件
 	for i, fid := range fileIds {
-		ioType := fio.StandardFIO
-		if db.options.MMapAtStartup {
-			ioType = fio.MemoryMap
-		}
-		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), ioType)
+		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), db.startupIOType())
 		if err != nil {
 			return err
 		}
+		dataFile.Cipher = db.cipher
 		// 最后一个ID最大的说明是当前活跃文件
 		if i == len(fileIds)-1 {
 			db.activeFile = dataFile
@@ -425,7 +740,8 @@ func (db *DB) loadDataFiles() error {
 }
 
 // 从数据文件中加载索引,遍历文件中的所有记录，并更新到内存中
-func (db *DB) loadIndexFromDataFiles() error {
+// from 之前的数据已经从索引检查点中加载，零值表示没有检查点
+func (db *DB) loadIndexFromDataFiles(ctx context.Context, from LogPosition) error {
 	// 没有文件，说明数据库是空的
 	if len(db.fileIds) == 0 {
 		return nil
@@ -443,22 +759,11 @@ func (db *DB) loadIndexFromDataFiles() error {
 		nonMergeFileId = fId
 	}
 
-	updateIndex := func(key []byte, logType data.LogRecordType, pos *data.LogRecordPos) {
-		var oldPos *data.LogRecordPos
-		if logType == data.LogRecordDeleted {
-			oldPos, _ = db.index.Delete(key)
-			db.reclaimSize += int64(pos.Size) // 增加删除标识的数据条目大小
-		} else {
-			db.index.Put(key, pos)
-		}
-		if oldPos != nil {
-			db.reclaimSize += int64(oldPos.Size) // 增加旧数据条目大小
-		}
-	}
+	now := time.Now().UnixNano()
 
 	// 暂存事务数据,事务ID=>[]数据信息
 	transactionRecords := make(map[uint64][]*data.TransactionRecord)
-	var currentSeqNo = nonTransactionSeqNo
+	var currentSeqNo = db.seqNo // 从检查点加载时为检查点中的序列号
 
 	// 遍历所有文件ID，处理文件中的记录
 	for i, fid := range db.fileIds {
@@ -467,6 +772,10 @@ func (db *DB) loadIndexFromDataFiles() error {
 		if hasMerge && fileId < nonMergeFileId {
 			continue
 		}
+		// 已经从索引检查点中加载了索引
+		if fileId < from.Fid {
+			continue
+		}
 		var dataFile *data.DataFile
 		if fileId == db.activeFile.FileId {
 			dataFile = db.activeFile
@@ -476,12 +785,25 @@ func (db *DB) loadIndexFromDataFiles() error {
 
 		//
 		var offset int64
+		if fileId == from.Fid {
+			offset = from.Offset
+		}
+		var tailErr error
 		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
 				if err == io.EOF {
 					break
 				}
+				// 最新的数据文件末尾可能是崩溃时没有写完整的记录，之后再截断
+				// 只读模式下可能是正在写入的进程还没有写完的记录
+				if err == data.ErrInvalidCRC && i == len(db.fileIds)-1 && (!db.options.StrictRecovery || db.options.ReadOnly) {
+					tailErr = err
+					break
+				}
 				return err
 			}
 			// 构建内存索引并保存
@@ -489,26 +811,12 @@ func (db *DB) loadIndexFromDataFiles() error {
 				Fid:    fileId,
 				Offset: offset,
 				Size:   uint32(size),
+				Expire: logRecord.Expire,
 			}
-
-			// 解析 key 拿到事务序列号
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == nonTransactionSeqNo { // 非事务操作，直接更新内存索引
-				updateIndex(realKey, logRecord.Type, logRecordPos)
-			} else {
-				if logRecord.Type == data.LogRecordTxFinished {
-					for _, txRecord := range transactionRecords[seqNo] {
-						updateIndex(txRecord.Record.Key, txRecord.Record.Type, txRecord.Pos)
-					}
-					delete(transactionRecords, seqNo)
-				} else { // 是writeBatch的数据，但还没有到结束标识
-					logRecord.Key = realKey
-					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
-						Record: logRecord,
-						Pos:    logRecordPos,
-					})
-				}
+			if logRecord.Type == data.LogRecordBlobRef {
+				logRecordPos.Blob = data.DecodeLogRecordPos(logRecord.Value)
 			}
+			seqNo := db.replayLogRecord(logRecord, logRecordPos, transactionRecords, now)
 
 			// 更新事务序列号
 			if seqNo > currentSeqNo {
@@ -517,23 +825,72 @@ func (db *DB) loadIndexFromDataFiles() error {
 
 			offset += size
 		}
-		// 如果当前是活跃文件，更新这个文件的writeOff
+		// 如果当前是活跃文件，截断末尾不完整的记录，并更新这个文件的writeOff
 		if i == len(db.fileIds)-1 {
+			if !db.options.StrictRecovery && !db.options.ReadOnly {
+				if err := db.truncateTornTail(dataFile, offset, tailErr); err != nil {
+					return err
+				}
+			}
 			db.activeFile.WriteOff = offset
 		}
+		db.listener.OnRecoveryProgress(fileId, offset)
 	}
 	// 更新序列号
 	db.seqNo = currentSeqNo
+	// 只读模式下还没有完成的事务可能是正在写入的，之后跟随写入的进程加载数据时继续处理
+	if db.options.ReadOnly {
+		db.followTxnRecords = transactionRecords
+	}
 
 	return nil
 }
 
-// 追加写数据到活跃文件中
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// 将数据文件中的一条记录更新到内存索引中，事务的数据在读到事务完成的标识之后才更新，返回记录的事务序列号
+// 在访问此方法前必须持有互斥锁
+func (db *DB) replayLogRecord(logRecord *data.LogRecord, logRecordPos *data.LogRecordPos,
+	transactionRecords map[uint64][]*data.TransactionRecord, now int64) uint64 {
+	// 解析 key 拿到事务序列号
+	realKey, seqNo := parseLogRecordKey(logRecord.Key)
+	if logRecord.Type == data.LogRecordRangeDeleted { // 范围删除，将区间内已经加载的key删除
+		for _, key := range db.keysInRange(realKey, logRecord.Value) {
+			if oldPos, _ := db.index.Delete(key); oldPos != nil {
+				db.reclaimPos(oldPos)
+			}
+		}
+		db.addReclaimSize(logRecordPos.Fid, int64(logRecordPos.Size))
+	} else if seqNo == nonTransactionSeqNo { // 非事务操作，直接更新内存索引
+		db.replayIndex(realKey, logRecord.Type, logRecordPos, now)
+	} else {
+		if logRecord.Type == data.LogRecordTxFinished {
+			for _, txRecord := range transactionRecords[seqNo] {
+				db.replayIndex(txRecord.Record.Key, txRecord.Record.Type, txRecord.Pos, now)
+			}
+			delete(transactionRecords, seqNo)
+		} else { // 是writeBatch的数据，但还没有到结束标识
+			logRecord.Key = realKey
+			transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
+				Record: logRecord,
+				Pos:    logRecordPos,
+			})
+		}
+	}
+	return seqNo
+}
 
-	return db.appendLogRecord(logRecord)
+// 更新key的内存索引，删除标识以及已经过期的数据会将key从索引中删除
+// 在访问此方法前必须持有互斥锁
+func (db *DB) replayIndex(key []byte, logType data.LogRecordType, pos *data.LogRecordPos, now int64) {
+	var oldPos *data.LogRecordPos
+	if logType == data.LogRecordDeleted || pos.IsExpired(now) {
+		oldPos, _ = db.index.Delete(key)
+		db.reclaimPos(pos) // 增加删除标识（或已过期）的数据条目大小
+	} else {
+		db.index.Put(key, pos)
+	}
+	if oldPos != nil {
+		db.reclaimPos(oldPos) // 增加旧数据条目大小
+	}
 }
 
 // 追加写数据到活跃文件中
@@ -548,59 +905,83 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 	}
 
 	// 写入数据编码
-	encRecord, size := data.EncodeLogRecord(logRecord)
+	encRecord, size := db.encodeLogRecord(logRecord)
 	// 如果写入的数据已经达到了活跃文件阀值，则关闭活跃文件，打开新的文件
 	if db.activeFile.WriteOff+size > db.options.DataFileSize {
 		// 先持久化数据文件，保证已有的数据持久到磁盘中
 		if err := db.activeFile.Sync(); err != nil {
 			return nil, err
 		}
+		atomic.AddUint64(&db.metrics.syncCount, 1)
+		db.listener.OnSync(db.activeFile.FileId)
 		// 将当前活跃文件转换为旧的数据文件
-		db.olderFiles[db.activeFile.FileId] = db.activeFile
+		oldFileId := db.activeFile.FileId
+		db.olderFiles[oldFileId] = db.activeFile
 		// 打开新的活跃文件
 		if err := db.setActiveDataFile(); err != nil {
 			return nil, err
 		}
+		db.listener.OnDataFileRotated(oldFileId, db.activeFile.FileId)
 	}
 	writeOff := db.activeFile.WriteOff
 	if err := db.activeFile.Write(encRecord); err != nil {
 		return nil, err
 	}
 	db.bytesWrite += uint(size)
+	atomic.AddUint64(&db.metrics.bytesWritten, uint64(size))
 	// 根据用户配置决定是否持久化
 	needSync := db.options.SyncWrite
 	// 用户没有设置每次写入持久化,但设置了达到一定字节持久化
 	if !needSync && db.options.BytesPerWrite > 0 && db.bytesWrite >= db.options.BytesPerWrite {
 		needSync = true
 	}
-	if needSync {
-		if err := db.activeFile.Sync(); err != nil {
+	// 组提交时由 leader 在这一组的写入完成之后统一持久化
+	if needSync && !db.syncDeferred {
+		if err := db.syncActiveFile(); err != nil {
 			return nil, err
 		}
-		// 清空累计值
-		if db.bytesWrite > 0 {
-			db.bytesWrite = 0
-		}
 	}
 	// 构造内存索引信息
 	pos := &data.LogRecordPos{
 		Fid:    db.activeFile.FileId,
 		Offset: writeOff,
 		Size:   uint32(size),
+		Expire: logRecord.Expire,
+	}
+	if logRecord.Type == data.LogRecordBlobRef {
+		pos.Blob = data.DecodeLogRecordPos(logRecord.Value)
 	}
 	return pos, nil
 }
 
+// 对 LogRecord 进行编码，根据配置对 value 进行压缩，压缩后没有变小则保留原始数据，配置了密钥则再进行加密
+func (db *DB) encodeLogRecord(logRecord *data.LogRecord) ([]byte, int64) {
+	if db.options.Compression == CompressionFlate && len(logRecord.Value) >= db.options.CompressionThreshold &&
+		len(logRecord.Value) > 0 {
+		if compressed, err := data.CompressValue(logRecord.Value); err == nil && len(compressed) < len(logRecord.Value) {
+			record := *logRecord
+			record.Value = compressed
+			record.Compressed = true
+			logRecord = &record
+		}
+	}
+	if db.cipher != nil {
+		logRecord = data.EncryptLogRecord(logRecord, db.cipher)
+	}
+	return data.EncodeLogRecord(logRecord)
+}
+
 // 追加写数据到活跃文件中
 func (db *DB) loadSeqNo() error {
 	fileName := path.Join(db.options.DirPath, data.SeqNoFileName)
 	if _, err := os.Stat(fileName); os.IsNotExist(err) {
 		return err
 	}
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
+	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath, fio.ReadOnlyFIO)
 	if err != nil {
 		return err
 	}
+	seqNoFile.Cipher = db.cipher
 	record, _, err := seqNoFile.ReadLogRecord(0)
 	if err != nil {
 		return err
@@ -615,18 +996,92 @@ func (db *DB) loadSeqNo() error {
 	return os.Remove(fileName) // 防止追加写多条，所以这里删除
 }
 
+// 根据配置的密钥初始化加密，并使用校验文件检查密钥是否正确
+// 第一次使用密钥打开数据库时写入校验文件，之后每次打开都要能用密钥正确解密校验文件
+func (db *DB) loadCipher() error {
+	// 只读模式不能创建校验密钥的文件
+	if db.options.ReadOnly {
+		cipher, err := verifyCipher(db.options.DirPath, db.options.EncryptionKey)
+		if err != nil {
+			return err
+		}
+		db.cipher = cipher
+		return nil
+	}
+	keyCheckFileName := filepath.Join(db.options.DirPath, data.KeyCheckFileName)
+	_, err := os.Stat(keyCheckFileName)
+	keyCheckFileExists := err == nil
+
+	if len(db.options.EncryptionKey) == 0 {
+		if keyCheckFileExists { // 数据库是加密的，但没有提供密钥
+			return ErrEncryptionKeyRequired
+		}
+		return nil
+	}
+
+	cipher, err := data.NewCipher(db.options.EncryptionKey)
+	if err != nil {
+		return err
+	}
+	keyCheckFile, err := data.OpenKeyCheckFile(db.options.DirPath, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = keyCheckFile.Close()
+	}()
+	keyCheckFile.Cipher = cipher
+
+	if keyCheckFileExists {
+		record, _, err := keyCheckFile.ReadLogRecord(0)
+		if err != nil || !bytes.Equal(record.Value, keyCheckValue) {
+			return ErrInvalidEncryptionKey
+		}
+	} else {
+		record := &data.LogRecord{
+			Key:   []byte(keyCheckKey),
+			Value: keyCheckValue,
+		}
+		if err = keyCheckFile.WriteLogRecord(record); err != nil {
+			return err
+		}
+		if err = keyCheckFile.Sync(); err != nil {
+			return err
+		}
+	}
+	db.cipher = cipher
+
+	return nil
+}
+
+// 打开数据目录中已有的文件使用的IO类型，只读模式下使用只读的文件IO，不会创建或者修改任何文件
+func (db *DB) fileIOType() fio.FileIOType {
+	if db.options.ReadOnly {
+		return fio.ReadOnlyFIO
+	}
+	return fio.StandardFIO
+}
+
+// 启动时加载文件使用的IO类型，内存文件映射会创建不存在的文件，只读模式下不使用
+func (db *DB) startupIOType() fio.FileIOType {
+	if db.options.MMapAtStartup && !db.options.ReadOnly {
+		return fio.MemoryMap
+	}
+	return db.fileIOType()
+}
+
 // 将数据文件的IO类型重置为文件IO
 func (db *DB) resetIOType() error {
 	if db.activeFile == nil {
 		return nil
 	}
 	// 设置活跃文件IO类型
-	if err := db.activeFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
+	if err := db.activeFile.SetIOManager(db.options.DirPath, db.fileIOType()); err != nil {
 		return err
 	}
 	// 设置旧的数据文件IO类型
 	for _, dataFile := range db.olderFiles {
-		if err := dataFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
+		if err := dataFile.SetIOManager(db.options.DirPath, db.fileIOType()); err != nil {
 			return err
 		}
 	}
@@ -644,5 +1099,50 @@ func checkOptions(options Options) error {
 	if options.DataFileMergeRatio < 0 || options.DataFileMergeRatio > 1 {
 		return errors.New("invalid ratio, must between 0 and 1")
 	}
+	if options.Compression != CompressionNone && options.Compression != CompressionFlate {
+		return errors.New("unsupported compression type")
+	}
+	if options.CompressionThreshold < 0 {
+		return errors.New("compression threshold must not be negative")
+	}
+	if keyLen := len(options.EncryptionKey); keyLen != 0 && keyLen != 16 && keyLen != 24 && keyLen != 32 {
+		return errors.New("encryption key must be 16, 24 or 32 bytes")
+	}
+	if len(options.EncryptionKey) > 0 && options.IndexType == IndexTypeBPlusTree {
+		return errors.New("encryption does not support the b+tree index")
+	}
+	if options.ValueThreshold < 0 {
+		return errors.New("value threshold must not be negative")
+	}
+	if options.BlobGarbageRatio < 0 || options.BlobGarbageRatio > 1 {
+		return errors.New("invalid blob garbage ratio, must between 0 and 1")
+	}
+	if options.DataFileGarbageRatio < 0 || options.DataFileGarbageRatio > 1 {
+		return errors.New("invalid data file garbage ratio, must between 0 and 1")
+	}
+	if options.SyncInterval < 0 {
+		return errors.New("sync interval must not be negative")
+	}
+	if options.ReadOnly && options.IndexType == IndexTypeBPlusTree {
+		return errors.New("read only mode does not support the b+tree index")
+	}
+	if options.Follow.Interval < 0 {
+		return errors.New("follow interval must not be negative")
+	}
+	if options.Follow.Interval > 0 && !options.ReadOnly {
+		return errors.New("follow is only supported in read only mode")
+	}
+	if options.AutoMerge.Enable {
+		if options.AutoMerge.CheckInterval <= 0 {
+			return errors.New("auto merge check interval must be greater than 0")
+		}
+		if options.AutoMerge.WindowStart < 0 || options.AutoMerge.WindowStart >= 24*time.Hour ||
+			options.AutoMerge.WindowEnd < 0 || options.AutoMerge.WindowEnd >= 24*time.Hour {
+			return errors.New("invalid auto merge window, must between 0 and 24h")
+		}
+		if options.AutoMerge.MinReclaimSize < 0 {
+			return errors.New("auto merge min reclaim size must not be negative")
+		}
+	}
 	return nil
 }
@@ -1,10 +1,13 @@
 package fdb
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/calmw/fdb/data"
 	"github.com/calmw/fdb/utils"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -212,6 +215,227 @@ func TestDB_Delete(t *testing.T) {
 	assert.Equal(t, val1, val2)
 }
 
+func TestDB_PutWithTTL(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-put-ttl")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.未过期之前可以正常读取
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(24), 100*time.Millisecond)
+	assert.Nil(t, err)
+	err = db.PutWithTTL(utils.GetTestKey(2), utils.RandomValue(24), time.Hour)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(3), utils.RandomValue(24))
+	assert.Nil(t, err)
+	val1, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val1)
+
+	// 2.过期之后 Get、ListKeys、Fold、Iterator 均不可见
+	time.Sleep(150 * time.Millisecond)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 2, len(db.ListKeys()))
+	var count int
+	err = db.Fold(func(key []byte, value []byte) bool {
+		assert.NotEqual(t, utils.GetTestKey(1), key)
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	iterator := db.NewIterator(DefaultIteratorOptions)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		assert.NotEqual(t, utils.GetTestKey(1), iterator.Key())
+	}
+	iterator.Close()
+
+	// 3.过期数据计入可回收数据量
+	stat := db.Stat()
+	assert.Equal(t, uint(2), stat.KeyNum)
+	assert.Greater(t, stat.ReclaimSize, int64(0))
+
+	// 4.重启之后过期时间依然有效
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val2, err := db2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.NotNil(t, val2)
+
+	// 5.merge 之后过期的数据被清理，未过期的数据保留过期时间
+	err = db2.Merge()
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(db3.ListKeys()))
+	pos := db3.index.Get(utils.GetTestKey(2))
+	assert.NotNil(t, pos)
+	assert.Greater(t, pos.Expire, int64(0))
+}
+
+func TestDB_Stat_Expired(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-stat-expired")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 3; i++ {
+		err := db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(24), 50*time.Millisecond)
+		assert.Nil(t, err)
+	}
+	err = db.Put(utils.GetTestKey(3), utils.RandomValue(24))
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	// Stat 不会清理过期的key
+	stat := db.Stat()
+	assert.Equal(t, uint(4), stat.KeyNum)
+	assert.Equal(t, int64(0), stat.ReclaimSize)
+	assert.Equal(t, 4, db.index.Size())
+
+	// 读取时清理过期的key
+	_, err = db.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+	stat = db.Stat()
+	assert.Equal(t, uint(3), stat.KeyNum)
+	assert.Greater(t, stat.ReclaimSize, int64(0))
+
+	// PickMergeFiles 清理剩下的过期key
+	db.PickMergeFiles()
+	stat = db.Stat()
+	assert.Equal(t, uint(1), stat.KeyNum)
+}
+
+func TestDB_Compression(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-compression")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 未开启压缩时写入的数据
+	value := bytes.Repeat([]byte("{\"name\":\"fdb\",\"type\":\"kv\"}"), 100)
+	err = db.Put(utils.GetTestKey(1), value)
+	assert.Nil(t, err)
+	rawSize := db.activeFile.WriteOff
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 开启压缩之后重启
+	opts.Compression = CompressionFlate
+	opts.CompressionThreshold = 64
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	err = db2.Put(utils.GetTestKey(2), value)
+	assert.Nil(t, err)
+	assert.Less(t, db2.activeFile.WriteOff-rawSize, rawSize)
+	// 小于阀值的数据不压缩
+	err = db2.Put(utils.GetTestKey(3), []byte("small"))
+	assert.Nil(t, err)
+
+	// 新旧数据都可以正常读取
+	val1, err := db2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val1)
+	val2, err := db2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val2)
+	val3, err := db2.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("small"), val3)
+
+	// 重启之后依然可以读取
+	err = db2.Close()
+	assert.Nil(t, err)
+	db3, err := Open(opts)
+	defer destroyDB(db3)
+	assert.Nil(t, err)
+	val4, err := db3.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val4)
+}
+
+func TestDB_Encryption(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-encryption")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.EncryptionKey = []byte("0123456789abcdef")
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("secret-value"))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 50; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	val, err := db.Get(utils.GetTestKey(60))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secret-value"), val)
+
+	// 数据文件中不包含明文
+	content, err := os.ReadFile(data.GetDataFileName(dir, 0))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(content, []byte("secret-value")))
+	assert.False(t, bytes.Contains(content, utils.GetTestKey(60)))
+
+	// merge 之后重启
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 密钥错误或者没有密钥都无法打开
+	wrongOpts := opts
+	wrongOpts.EncryptionKey = []byte("fedcba9876543210")
+	_, err = Open(wrongOpts)
+	assert.Equal(t, ErrInvalidEncryptionKey, err)
+	wrongOpts.EncryptionKey = nil
+	_, err = Open(wrongOpts)
+	assert.Equal(t, ErrEncryptionKeyRequired, err)
+	// B+树索引文件中的 key 无法加密
+	wrongOpts.EncryptionKey = opts.EncryptionKey
+	wrongOpts.IndexType = IndexTypeBPlusTree
+	_, err = Open(wrongOpts)
+	assert.NotNil(t, err)
+	_, err = os.Stat(filepath.Join(dir, "bptree-index"))
+	assert.True(t, os.IsNotExist(err))
+
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	assert.Equal(t, 50, len(db2.ListKeys()))
+	val, err = db2.Get(utils.GetTestKey(60))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("secret-value"), val)
+	hint, err := os.ReadFile(filepath.Join(dir, data.HintFileName))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(hint, utils.GetTestKey(60)))
+}
+
 func TestDB_ListKeys(t *testing.T) {
 	opts := DefaultOption
 	dir, _ := os.MkdirTemp("", "fdb-go-list-keys")
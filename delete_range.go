@@ -0,0 +1,94 @@
+package fdb
+
+import (
+	"bytes"
+	"github.com/calmw/fdb/data"
+	"sync/atomic"
+)
+
+// DeleteRange 删除 [start, end) 区间内的所有key，end 为空表示删除 start 之后的所有key
+// 只会写入一条范围删除记录，而不是为每个key写入一条删除记录
+func (db *DB) DeleteRange(start, end []byte) error {
+	if len(start) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return ErrInvalidRange
+	}
+	return db.commit(func() error {
+		return db.deleteRangeWithoutLock(start, end)
+	}, db.options.SyncWrite)
+}
+
+// DeletePrefix 删除所有以 prefix 为前缀的key
+func (db *DB) DeletePrefix(prefix []byte) error {
+	if len(prefix) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.commit(func() error {
+		return db.deleteRangeWithoutLock(prefix, prefixUpperBound(prefix))
+	}, db.options.SyncWrite)
+}
+
+// 写入范围删除记录，并将区间内的key从内存索引中删除
+// 在访问此方法前必须持有互斥锁
+func (db *DB) deleteRangeWithoutLock(start, end []byte) error {
+	// 区间内没有key，直接返回
+	keys := db.keysInRange(start, end)
+	if len(keys) == 0 {
+		return nil
+	}
+
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithSeq(start, nonTransactionSeqNo),
+		Value: end,
+		Type:  data.LogRecordRangeDeleted,
+	}
+	pos, err := db.appendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	db.reclaimPos(pos) // 范围删除记录本身也是无效数据
+
+	// 一次范围删除是一次写入，产生的事件使用同一个序列号
+	writeSeqNo := atomic.AddUint64(&db.writeSeqNo, 1)
+	for _, key := range keys {
+		oldPos, ok := db.indexDelete(key)
+		if !ok {
+			return ErrIndexUpdateFailed
+		}
+		if oldPos != nil {
+			db.reclaimPos(oldPos)
+		}
+		db.notifyWatchers(key, nil, true, writeSeqNo)
+	}
+
+	return nil
+}
+
+// 取出内存索引中 [start, end) 区间内的所有key，end 为空表示没有终点
+// 只遍历区间内的key，不会像迭代器一样先取出索引中的所有数据
+// 在访问此方法前必须持有互斥锁
+func (db *DB) keysInRange(start, end []byte) [][]byte {
+	var keys [][]byte
+	db.index.AscendRange(start, end, func(key []byte, _ *data.LogRecordPos) bool {
+		// B+树返回的key在事务关闭之后不再有效，需要拷贝，遍历结束之后才能删除索引
+		keys = append(keys, append([]byte{}, key...))
+		return true
+	})
+	return keys
+}
+
+// 计算前缀区间的终点，即比所有以 prefix 为前缀的key都大的最小key
+// prefix 全部是 0xff 时没有这样的key，返回nil表示没有终点
+func prefixUpperBound(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package fdb
+
+import (
+	"fmt"
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_DeleteRange(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-delete-range")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+
+	// 1.非法的区间
+	err = db.DeleteRange(nil, utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyIsEmpty, err)
+	err = db.DeleteRange(utils.GetTestKey(10), utils.GetTestKey(10))
+	assert.Equal(t, ErrInvalidRange, err)
+
+	// 2.删除 [100, 200) 区间内的key，只写入一条记录
+	writeOff := db.activeFile.WriteOff
+	err = db.DeleteRange(utils.GetTestKey(100), utils.GetTestKey(200))
+	assert.Nil(t, err)
+	assert.Less(t, db.activeFile.WriteOff-writeOff, int64(64))
+	_, err = db.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(100))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(199))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(200))
+	assert.Nil(t, err)
+	assert.Equal(t, 900, len(db.ListKeys()))
+
+	// 3.删除之后重新写入的key不受影响
+	err = db.Put(utils.GetTestKey(150), []byte("new value"))
+	assert.Nil(t, err)
+
+	// 4.没有终点，删除之后的所有key
+	err = db.DeleteRange(utils.GetTestKey(900), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 801, len(db.ListKeys()))
+	assert.Greater(t, db.Stat().ReclaimSize, int64(0))
+
+	// 5.重启之后依然生效
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	assert.Equal(t, 801, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(120))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(150))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), val)
+	_, err = db2.Get(utils.GetTestKey(950))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_DeletePrefix(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-delete-prefix")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 500; i++ {
+		for _, tenant := range []string{"tenant-a", "tenant-b", "tenant-c"} {
+			err := db.Put([]byte(fmt.Sprintf("%s/%d", tenant, i)), utils.RandomValue(64))
+			assert.Nil(t, err)
+		}
+	}
+	err = db.Put([]byte{0xff, 0xff}, []byte("max"))
+	assert.Nil(t, err)
+
+	watcher := db.Watch([]byte("tenant-b"), DefaultWatchOptions)
+	defer watcher.Close()
+
+	err = db.DeletePrefix([]byte("tenant-b"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1001, len(db.ListKeys()))
+	_, err = db.Get([]byte("tenant-b/10"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get([]byte("tenant-c/10"))
+	assert.Nil(t, err)
+	assert.Equal(t, 500, len(watcher.Events()))
+	event := <-watcher.Events()
+	assert.True(t, event.Deleted)
+
+	// 前缀全部是 0xff 时没有终点
+	err = db.DeletePrefix([]byte{0xff})
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db.ListKeys()))
+
+	// merge 之后重启依然生效
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+	_, err = db2.Get([]byte("tenant-b/10"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get([]byte("tenant-a/10"))
+	assert.Nil(t, err)
+}
@@ -3,14 +3,32 @@ package fdb
 import "errors"
 
 var (
-	ErrKeyIsEmpty             = errors.New("the key is empty")
-	ErrIndexUpdateFailed      = errors.New("failed to update index")
-	ErrKeyNotFound            = errors.New("key not found in database")
-	ErrDataFileNotFound       = errors.New("data file not found")
-	ErrDataDirectoryCorrupted = errors.New("the database directory maybe corrupted")
-	ErrExceedMaxBatchNum      = errors.New("exceed the max batch num")
-	ErrMergeIsProgress        = errors.New("merge is in progress, try again later")
-	ErrDatabaseIsUsing        = errors.New("database is used by another process")
-	ErrMergeRatioUnreached    = errors.New("the merge ratio do not reach the option")
-	ErrNotEnoughSpaceForMerge = errors.New("not enough disk space for merge")
+	ErrKeyIsEmpty               = errors.New("the key is empty")
+	ErrIndexUpdateFailed        = errors.New("failed to update index")
+	ErrKeyNotFound              = errors.New("key not found in database")
+	ErrDataFileNotFound         = errors.New("data file not found")
+	ErrDataDirectoryCorrupted   = errors.New("the database directory maybe corrupted")
+	ErrExceedMaxBatchNum        = errors.New("exceed the max batch num")
+	ErrMergeIsProgress          = errors.New("merge is in progress, try again later")
+	ErrDatabaseIsUsing          = errors.New("database is used by another process")
+	ErrMergeRatioUnreached      = errors.New("the merge ratio do not reach the option")
+	ErrNotEnoughSpaceForMerge   = errors.New("not enough disk space for merge")
+	ErrSnapshotReleased         = errors.New("the snapshot has been released")
+	ErrTxnConflict              = errors.New("transaction conflict, the keys read have been modified")
+	ErrTxnFinished              = errors.New("transaction has been committed or rolled back")
+	ErrInvalidEncryptionKey     = errors.New("the encryption key is invalid for the database")
+	ErrEncryptionKeyRequired    = errors.New("the database is encrypted, encryption key is required")
+	ErrPositionCompacted        = errors.New("the log position has been compacted by merge")
+	ErrInvalidLogPosition       = errors.New("the log position is beyond the end of data file")
+	ErrBackupCorrupted          = errors.New("the backup is corrupted")
+	ErrRestoreDirNotEmpty       = errors.New("the restore target directory is not empty")
+	ErrInvalidBackupArchive     = errors.New("invalid backup archive")
+	ErrInvalidRange             = errors.New("the range start must be less than the end")
+	ErrInvalidCounter           = errors.New("the value is not a 8-byte counter")
+	ErrBlobCompactionIsProgress = errors.New("blob compaction is in progress, try again later")
+	ErrMergeNotApplied          = errors.New("the previous merge will be applied when the database is reopened")
+	ErrInvalidMergeFile         = errors.New("only older data files can be merged")
+	ErrRepairUnsupportedIndex   = errors.New("repair does not support the b+tree index")
+	ErrReadOnly                 = errors.New("the database is opened in read only mode")
+	ErrDataFileCorrupted        = errors.New("the data file is corrupted before its tail, use Repair to salvage the valid records")
 )
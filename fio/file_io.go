@@ -19,6 +19,15 @@ func NewFileIOManager(fileName string) (*FileIO, error) {
 	return &FileIO{fd: fd}, nil
 }
 
+// NewReadOnlyFileIOManager 以只读方式打开已有的文件，写入时返回错误
+func NewReadOnlyFileIOManager(fileName string) (*FileIO, error) {
+	fd, err := os.OpenFile(fileName, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &FileIO{fd: fd}, nil
+}
+
 func (f *FileIO) Read(b []byte, offset int64) (int, error) {
 	return f.fd.ReadAt(b, offset)
 }
@@ -3,6 +3,7 @@ package fio
 import (
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -38,3 +39,30 @@ func TestFileIO_Read(t *testing.T) {
 	n, err = io.Write([]byte("abc"))
 	t.Log(n, err)
 }
+
+func TestNewReadOnlyFileIO(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "read-only-a.data")
+	defer destroyFile(path)
+
+	// 文件不存在时不会创建
+	_, err := NewReadOnlyFileIOManager(path)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	fio, err := NewFileIOManager(path)
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("abc"))
+	assert.Nil(t, err)
+
+	io, err := NewReadOnlyFileIOManager(path)
+	assert.Nil(t, err)
+	b := make([]byte, 3)
+	n, err := io.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []byte("abc"), b)
+	_, err = io.Write([]byte("d"))
+	assert.NotNil(t, err)
+	assert.Nil(t, io.Close())
+}
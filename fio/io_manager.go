@@ -7,6 +7,7 @@ type FileIOType byte
 const (
 	StandardFIO FileIOType = iota // 标准文件IO
 	MemoryMap                     // 内存文件映射
+	ReadOnlyFIO                   // 只读的标准文件IO，文件不存在时返回错误，不会创建或修改文件
 )
 
 // IOManager 抽象IO管理接口，可以介入不同的IO类型，目前支持标准文件IO
@@ -25,6 +26,8 @@ func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
 		return NewFileIOManager(fileName)
 	case MemoryMap:
 		return NewMMapIOManager(fileName)
+	case ReadOnlyFIO:
+		return NewReadOnlyFileIOManager(fileName)
 	default:
 		panic("unsupported io type")
 	}
@@ -0,0 +1,285 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/fio"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Refresh 只读模式下加载写入的进程在这之前新写入的数据，包括活跃文件中追加的记录、新的数据文件以及新的 blob 文件
+// 写入的进程重启时应用了 merge 的结果，正在跟随的数据文件会被删除或者替换，这时重新加载整个数据目录
+// 非只读模式下所有的数据都已经在内存索引中，直接返回
+func (db *DB) Refresh() error {
+	if !db.options.ReadOnly {
+		return nil
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	replaced, err := db.isFollowFileReplaced()
+	if err != nil {
+		return err
+	}
+	if replaced {
+		return db.reloadFollower()
+	}
+	if err := db.followDataFiles(); err != nil {
+		return err
+	}
+	return db.followBlobFiles()
+}
+
+// 启动后台跟随写入进程的协程，每隔 Follow.Interval 加载一次新写入的数据
+func (db *DB) startFollow() {
+	db.followStop = make(chan struct{})
+	db.followDone = make(chan struct{})
+	go db.follow()
+}
+
+// 通知后台跟随写入进程的协程退出，并等待正在进行的加载完成
+func (db *DB) stopFollow() {
+	if db.followStop == nil {
+		return
+	}
+	close(db.followStop)
+	<-db.followDone
+	db.followStop = nil
+}
+
+func (db *DB) follow() {
+	defer close(db.followDone)
+
+	ticker := time.NewTicker(db.options.Follow.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.followStop:
+			return
+		case <-ticker.C:
+			if err := db.Refresh(); err != nil {
+				if db.options.Follow.OnError != nil {
+					db.options.Follow.OnError(err)
+				}
+				db.listener.OnBackgroundError(err)
+			}
+		}
+	}
+}
+
+// 记录正在跟随的活跃文件，之后通过比较文件判断是否被 merge 替换
+// 在访问此方法前必须持有互斥锁
+func (db *DB) followActiveFile() error {
+	if db.activeFile == nil {
+		return nil
+	}
+	fileInfo, err := os.Stat(data.GetDataFileName(db.options.DirPath, db.activeFile.FileId))
+	if err != nil {
+		return err
+	}
+	db.followFileInfo = fileInfo
+	return nil
+}
+
+// 正在跟随的活跃文件是否已经被删除或者替换
+// 只有 ID 小于 merge 时的活跃文件的数据文件才会被替换，所以活跃文件被替换说明之后的文件也可能不再是原来的文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) isFollowFileReplaced() (bool, error) {
+	if db.followFileInfo == nil {
+		return false, nil
+	}
+	fileInfo, err := os.Stat(data.GetDataFileName(db.options.DirPath, db.activeFile.FileId))
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !os.SameFile(fileInfo, db.followFileInfo), nil
+}
+
+// 从活跃文件上一次读到的位置继续加载，读到末尾之后如果已经有了新的数据文件，则切换到新的文件继续加载
+// 在访问此方法前必须持有互斥锁
+func (db *DB) followDataFiles() error {
+	if db.followTxnRecords == nil {
+		db.followTxnRecords = make(map[uint64][]*data.TransactionRecord)
+	}
+	// 打开时还没有数据文件，写入的进程从ID为0的文件开始写入
+	if db.activeFile == nil {
+		ok, err := db.openFollowDataFile(0)
+		if err != nil || !ok {
+			return err
+		}
+	}
+
+	now := time.Now().UnixNano()
+	for {
+		// 先检查是否已经有了新的数据文件，有的话当前文件不会再被写入，读到末尾之后就可以切换到新的文件
+		nextFileId := db.activeFile.FileId + 1
+		_, err := os.Stat(data.GetDataFileName(db.options.DirPath, nextFileId))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		hasNext := err == nil
+
+		offset := db.activeFile.WriteOff
+		for {
+			logRecord, size, err := db.activeFile.ReadLogRecord(offset)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				// 写入的进程还没有写完的记录，下次再加载
+				if err == data.ErrInvalidCRC && !hasNext {
+					break
+				}
+				return err
+			}
+			logRecordPos := &data.LogRecordPos{
+				Fid:    db.activeFile.FileId,
+				Offset: offset,
+				Size:   uint32(size),
+				Expire: logRecord.Expire,
+			}
+			if logRecord.Type == data.LogRecordBlobRef {
+				logRecordPos.Blob = data.DecodeLogRecordPos(logRecord.Value)
+			}
+			if seqNo := db.replayLogRecord(logRecord, logRecordPos, db.followTxnRecords, now); seqNo > db.seqNo {
+				db.seqNo = seqNo
+			}
+			offset += size
+		}
+		db.activeFile.WriteOff = offset
+		if !hasNext {
+			return nil
+		}
+
+		previous := db.activeFile
+		if _, err := db.openFollowDataFile(nextFileId); err != nil {
+			return err
+		}
+		db.olderFiles[previous.FileId] = previous
+		// 新的文件打开之前，写入的进程可能已经应用了 merge 的结果，打开的文件可能是 merge 之后的文件
+		fileInfo, err := os.Stat(data.GetDataFileName(db.options.DirPath, previous.FileId))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err != nil || !os.SameFile(fileInfo, db.followFileInfo) {
+			return db.reloadFollower()
+		}
+		if err := db.followActiveFile(); err != nil {
+			return err
+		}
+	}
+}
+
+// 打开数据文件作为新的活跃文件，文件不存在时返回 false
+// 以只读方式打开，写入的进程在这期间删除了文件时不会重新创建出空文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) openFollowDataFile(fileId uint32) (bool, error) {
+	dataFile, err := data.OpenDataFile(db.options.DirPath, fileId, fio.ReadOnlyFIO)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	dataFile.Cipher = db.cipher
+	db.activeFile = dataFile
+	if db.followFileInfo == nil {
+		if err := db.followActiveFile(); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// 打开写入的进程新创建的 blob 文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) followBlobFiles() error {
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	var fileIds []int
+	for _, entry := range dirEntries {
+		if !strings.HasSuffix(entry.Name(), data.BlobFileNameSuffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.BlobFileNameSuffix))
+		if err != nil {
+			return ErrDataDirectoryCorrupted
+		}
+		if uint32(fileId) >= db.nextBlobFid {
+			fileIds = append(fileIds, fileId)
+		}
+	}
+	sort.Ints(fileIds)
+	for _, fid := range fileIds {
+		blobFile, err := data.OpenBlobFile(db.options.DirPath, uint32(fid), fio.ReadOnlyFIO)
+		// 读取目录之后文件已经被 CompactBlobs 删除
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		blobFile.Cipher = db.cipher
+		if db.activeBlobFile != nil {
+			db.olderBlobFiles[db.activeBlobFile.FileId] = db.activeBlobFile
+		}
+		db.activeBlobFile = blobFile
+		db.nextBlobFid = uint32(fid) + 1
+		atomic.StoreInt32(&db.blobFilesExist, 1)
+	}
+	return nil
+}
+
+// 重新加载整个数据目录，替换掉当前的内存索引和数据文件
+// 原来的文件在关闭数据库时才会关闭，已经创建的快照仍然可以读取
+// 在访问此方法前必须持有互斥锁
+func (db *DB) reloadFollower() error {
+	options := db.options
+	options.Follow.Interval = 0
+	reloaded, err := Open(options)
+	if err != nil {
+		return err
+	}
+
+	if db.activeFile != nil {
+		db.retiredFiles = append(db.retiredFiles, db.activeFile)
+	}
+	for _, dataFile := range db.olderFiles {
+		db.retiredFiles = append(db.retiredFiles, dataFile)
+	}
+	if db.activeBlobFile != nil {
+		db.retiredFiles = append(db.retiredFiles, db.activeBlobFile)
+	}
+	for _, blobFile := range db.olderBlobFiles {
+		db.retiredFiles = append(db.retiredFiles, blobFile)
+	}
+	_ = db.index.Close()
+
+	db.activeFile = reloaded.activeFile
+	db.olderFiles = reloaded.olderFiles
+	db.index = reloaded.index
+	db.seqNo = reloaded.seqNo
+	db.reclaimSize = reloaded.reclaimSize
+	db.fileGarbage = reloaded.fileGarbage
+	db.rewrittenFiles = reloaded.rewrittenFiles
+	db.followFileInfo = reloaded.followFileInfo
+	db.followTxnRecords = reloaded.followTxnRecords
+	db.activeBlobFile = reloaded.activeBlobFile
+	db.olderBlobFiles = reloaded.olderBlobFiles
+	db.blobGarbage = reloaded.blobGarbage
+	db.nextBlobFid = reloaded.nextBlobFid
+	if reloaded.hasBlobFiles() {
+		atomic.StoreInt32(&db.blobFilesExist, 1)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDB_Refresh(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-refresh")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.ValueThreshold = 512
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.打开时数据目录是空的
+	roOpts := opts
+	roOpts.ReadOnly = true
+	follower, err := Open(roOpts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = follower.Close()
+	}()
+
+	values := make(map[int][]byte)
+	for i := 0; i < 500; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	err = follower.Refresh()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(500), follower.Stat().KeyNum)
+
+	// 2.新写入的数据跨越了多个数据文件，包括 WriteBatch、删除以及分离存储的 value
+	for i := 500; i < 1000; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 1000; i < 1100; i++ {
+		values[i] = utils.RandomValue(1024)
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), values[i]))
+	}
+	assert.Nil(t, wb.Commit())
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		delete(values, i)
+	}
+	err = follower.Refresh()
+	assert.Nil(t, err)
+	assert.Greater(t, len(follower.olderFiles), 1)
+	checkFollowerValues(t, follower, values)
+
+	// 3.写入的进程重启之后应用了 merge 的结果，数据文件被替换
+	snapshot := follower.Snapshot()
+	defer snapshot.Release()
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	for i := 2000; i < 2100; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db2.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	err = follower.Refresh()
+	assert.Nil(t, err)
+	assert.NotEmpty(t, follower.retiredFiles)
+	checkFollowerValues(t, follower, values)
+	// 替换之前创建的快照仍然可以读取
+	val, err := snapshot.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.Equal(t, values[500], val)
+
+	// 4.后台定时加载
+	roOpts.Follow.Interval = 10 * time.Millisecond
+	var followErr error
+	roOpts.Follow.OnError = func(err error) {
+		followErr = err
+	}
+	follower2, err := Open(roOpts)
+	assert.Nil(t, err)
+	err = db2.Put(utils.GetTestKey(3000), []byte("follow"))
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		val, err := follower2.Get(utils.GetTestKey(3000))
+		return err == nil && string(val) == "follow"
+	}, 5*time.Second, 10*time.Millisecond)
+	err = follower2.Close()
+	assert.Nil(t, err)
+	assert.Nil(t, followErr)
+
+	// 只有只读模式才能跟随写入的进程
+	opts.Follow.Interval = time.Second
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+}
+
+func checkFollowerValues(t *testing.T, follower *DB, values map[int][]byte) {
+	assert.Equal(t, uint(len(values)), follower.Stat().KeyNum)
+	for i, value := range values {
+		val, err := follower.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	_, err := follower.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestDB_Refresh_ReadOnlyFiles(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-refresh-read-only")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.ValueThreshold = 512
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	roOpts := opts
+	roOpts.ReadOnly = true
+	follower, err := Open(roOpts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = follower.Close()
+	}()
+	for i := 0; i < 500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = db.Put(utils.GetTestKey(500), utils.RandomValue(1024))
+	assert.Nil(t, err)
+	err = follower.Refresh()
+	assert.Nil(t, err)
+	assert.Equal(t, uint(501), follower.Stat().KeyNum)
+
+	// 跟随打开的数据文件和 blob 文件都是只读的
+	assert.Greater(t, len(follower.olderFiles), 0)
+	_, err = follower.activeFile.IoManager.Write([]byte("x"))
+	assert.NotNil(t, err)
+	_, err = follower.activeBlobFile.IoManager.Write([]byte("x"))
+	assert.NotNil(t, err)
+
+	// 文件不存在时不会被创建出来
+	follower.mu.Lock()
+	ok, err := follower.openFollowDataFile(follower.activeFile.FileId + 100)
+	follower.mu.Unlock()
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, err = os.Stat(data.GetDataFileName(dir, follower.activeFile.FileId+100))
+	assert.True(t, os.IsNotExist(err))
+}
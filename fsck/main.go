@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/calmw/fdb"
+	"os"
+)
+
+// 离线检查数据目录，输出 JSON 格式的检查结果，发现问题时退出码为1，无法检查时退出码为2
+// go run ./fsck -dir ./fdb
+func main() {
+	dir := flag.String("dir", fdb.DefaultOption.DirPath, "database dir path")
+	key := flag.String("key", "", "encryption key of the database")
+	flag.Parse()
+
+	opts := fdb.DefaultVerifyOptions
+	if *key != "" {
+		opts.EncryptionKey = []byte(*key)
+	}
+	report, err := fdb.Verify(*dir, opts)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to verify %s: %v\n", *dir, err)
+		os.Exit(2)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(report); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to encode report: %v\n", err)
+		os.Exit(2)
+	}
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
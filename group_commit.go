@@ -0,0 +1,132 @@
+package fdb
+
+import "github.com/calmw/fdb/data"
+
+// 等待组提交的写入请求
+type commitRequest struct {
+	fn     func() error // 在持有互斥锁时执行的写入操作，不需要自己持久化
+	err    error
+	leader bool // 被唤醒之后是否成为 leader
+	done   chan struct{}
+}
+
+// 执行写入操作，sync 为 false 时直接加锁执行
+// sync 为 true 时使用组提交：写入请求进入队列，由队首的 leader 在同一次加锁中执行队列中所有的写入，然后只持久化一次，
+// 持久化完成之后才通知这一组的所有写入请求，所以返回成功的写入和之前一样都已经持久化
+// 这一组写入对内存索引的修改和产生的事件，在持久化成功之后才会生效并发送给观察者，持久化失败时回滚内存索引并丢弃事件
+func (db *DB) commit(fn func() error, sync bool) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if !sync {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		return fn()
+	}
+
+	req := &commitRequest{fn: fn, done: make(chan struct{})}
+	db.commitMu.Lock()
+	db.commitQueue = append(db.commitQueue, req)
+	// 队列中已经有 leader，等待 leader 完成这一组的提交
+	if len(db.commitQueue) > 1 {
+		db.commitMu.Unlock()
+		<-req.done
+		if !req.leader {
+			return req.err
+		}
+		db.commitMu.Lock()
+	}
+	group := db.commitQueue
+	db.commitMu.Unlock()
+
+	db.commitGroup(group)
+
+	db.commitMu.Lock()
+	db.commitQueue = db.commitQueue[len(group):]
+	// 提交期间进入队列的写入请求，由队首的请求作为下一组的 leader
+	if len(db.commitQueue) > 0 {
+		next := db.commitQueue[0]
+		next.leader = true
+		close(next.done)
+	}
+	db.commitMu.Unlock()
+
+	for _, r := range group[1:] {
+		close(r.done)
+	}
+	return req.err
+}
+
+// 执行一组写入请求，所有的写入完成之后统一持久化
+func (db *DB) commitGroup(group []*commitRequest) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.syncDeferred = true
+	for _, r := range group {
+		r.err = r.fn()
+	}
+	db.syncDeferred = false
+	undos, events := db.commitUndos, db.commitEvents
+	db.commitUndos, db.commitEvents = nil, nil
+
+	if err := db.syncActiveFile(); err != nil {
+		db.rollbackIndex(undos)
+		for _, r := range group {
+			if r.err == nil {
+				r.err = err
+			}
+		}
+		return
+	}
+	for _, event := range events {
+		db.sendWatchEvent(event)
+	}
+}
+
+// 组提交期间内存索引的一次修改，持久化失败时用来回滚
+type indexUndo struct {
+	key    []byte
+	oldPos *data.LogRecordPos // 修改之前的位置，为空表示之前不存在
+}
+
+// 更新内存索引，组提交期间记录修改之前的位置
+// 在访问此方法前必须持有互斥锁
+func (db *DB) indexPut(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	oldPos := db.index.Put(key, pos)
+	if db.syncDeferred {
+		db.commitUndos = append(db.commitUndos, &indexUndo{key: key, oldPos: oldPos})
+	}
+	return oldPos
+}
+
+// 从内存索引中删除key，组提交期间记录删除之前的位置
+// 在访问此方法前必须持有互斥锁
+func (db *DB) indexDelete(key []byte) (*data.LogRecordPos, bool) {
+	oldPos, ok := db.index.Delete(key)
+	if ok && oldPos != nil && db.syncDeferred {
+		db.commitUndos = append(db.commitUndos, &indexUndo{key: key, oldPos: oldPos})
+	}
+	return oldPos, ok
+}
+
+// 按照相反的顺序撤销组提交中对内存索引的修改，被撤销的记录成为无效数据，恢复的记录不再是无效数据
+// 在访问此方法前必须持有互斥锁
+func (db *DB) rollbackIndex(undos []*indexUndo) {
+	for i := len(undos) - 1; i >= 0; i-- {
+		undo := undos[i]
+		var newPos *data.LogRecordPos
+		if undo.oldPos == nil {
+			newPos, _ = db.index.Delete(undo.key)
+		} else {
+			newPos = db.index.Put(undo.key, undo.oldPos)
+			db.addReclaimSize(undo.oldPos.Fid, -int64(undo.oldPos.Size))
+			if undo.oldPos.Blob != nil {
+				db.blobGarbage[undo.oldPos.Blob.Fid] -= int64(undo.oldPos.Blob.Size)
+			}
+		}
+		if newPos != nil {
+			db.reclaimPos(newPos)
+		}
+	}
+}
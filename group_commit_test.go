@@ -0,0 +1,146 @@
+package fdb
+
+import (
+	"errors"
+	"github.com/calmw/fdb/fio"
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestDB_GroupCommit(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-group-commit")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.SyncWrite = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 并发的 Put、Delete、WriteBatch、Txn 和 Increment 一起组提交
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g * 100; i < (g+1)*100; i++ {
+				assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("group-commit")))
+			}
+			for i := g * 100; i < g*100+10; i++ {
+				assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+			}
+			_, err := db.Increment([]byte("counter"), 1)
+			assert.Nil(t, err)
+		}(g)
+	}
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			wb := db.NewWriteBatch(WriteBatchOptions{MaxBatchNum: 100, SyncWrites: false})
+			for i := 1000 + g*50; i < 1000+(g+1)*50; i++ {
+				assert.Nil(t, wb.Put(utils.GetTestKey(i), []byte("group-commit")))
+			}
+			assert.Nil(t, wb.Commit())
+
+			txn := db.NewTxn(DefaultWriteBatchOptions)
+			assert.Nil(t, txn.Put(utils.GetTestKey(2000+g), []byte("group-commit")))
+			assert.Nil(t, txn.Commit())
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, uint(800-80+200+4+1), db.Stat().KeyNum)
+
+	// 事务冲突检查在 leader 持有锁时执行
+	txn := db.NewTxn(DefaultWriteBatchOptions)
+	_, err = txn.Get(utils.GetTestKey(2000))
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Put(utils.GetTestKey(2000), []byte("txn")))
+	assert.Nil(t, db.Put(utils.GetTestKey(2000), []byte("put")))
+	assert.Equal(t, ErrTxnConflict, txn.Commit())
+
+	swapped, err := db.CompareAndSwap(utils.GetTestKey(2000), []byte("put"), []byte("cas"))
+	assert.Nil(t, err)
+	assert.True(t, swapped)
+	written, err := db.PutIfAbsent(utils.GetTestKey(2000), []byte("absent"))
+	assert.Nil(t, err)
+	assert.False(t, written)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 重启之后数据完整
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Equal(t, uint(800-80+200+4+1), db2.Stat().KeyNum)
+	val, err := db2.Get(utils.GetTestKey(2000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("cas"), val)
+	counter, err := db2.Increment([]byte("counter"), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(8), counter)
+	_, err = db2.Get(utils.GetTestKey(5))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+// Sync 总是失败的IO，用来模拟持久化失败
+type failingSyncIO struct {
+	fio.IOManager
+}
+
+func (f *failingSyncIO) Sync() error {
+	return errors.New("sync failed")
+}
+
+func TestDB_GroupCommit_SyncFailed(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-group-commit-sync-failed")
+	opts.DirPath = dir
+	opts.SyncWrite = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("old")))
+	assert.Nil(t, db.Put(utils.GetTestKey(2), []byte("old")))
+	reclaimSize := db.Stat().ReclaimSize
+	watcher := db.Watch(nil, WatchOptions{BufferSize: 16})
+	defer watcher.Close()
+
+	// 持久化失败时内存索引保持不变，也不会产生事件
+	ioManager := db.activeFile.IoManager
+	db.activeFile.IoManager = &failingSyncIO{IOManager: ioManager}
+	assert.NotNil(t, db.Put(utils.GetTestKey(1), []byte("new")))
+	assert.NotNil(t, db.Put(utils.GetTestKey(3), []byte("new")))
+	assert.NotNil(t, db.Delete(utils.GetTestKey(2)))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put(utils.GetTestKey(2), []byte("batch")))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(1)))
+	assert.NotNil(t, wb.Commit())
+	db.activeFile.IoManager = ioManager
+
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("old"), val)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("old"), val)
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 0, len(watcher.Events()))
+	// 没有生效的写入都是无效数据
+	assert.Greater(t, db.Stat().ReclaimSize, reclaimSize)
+
+	// 持久化成功之后才发送事件
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("new")))
+	event := <-watcher.Events()
+	assert.Equal(t, utils.GetTestKey(1), event.Key)
+	assert.Equal(t, []byte("new"), event.Value)
+}
@@ -28,6 +28,7 @@ func main() {
 	http.HandleFunc("/fdb/delete", handleDelete)
 	http.HandleFunc("/fdb/listkeys", handleListKeys)
 	http.HandleFunc("/fdb/stat", handleStat)
+	http.Handle("/fdb/metrics", db.MetricsHandler())
 
 	// 启动 HTTP 服务
 	_ = http.ListenAndServe(":8080", nil)
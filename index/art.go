@@ -79,6 +79,30 @@ func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
 	return newArtIterator(art.tree, reverse)
 }
 
+// AscendRange 按顺序遍历 [start, end) 区间内的key，只遍历 start 和 end 的公共前缀下的节点
+func (art *AdaptiveRadixTree) AscendRange(start, end []byte, handleFn func(key []byte, pos *data.LogRecordPos) bool) {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+	var prefixLen int
+	for len(end) > 0 && prefixLen < len(start) && prefixLen < len(end) && start[prefixLen] == end[prefixLen] {
+		prefixLen++
+	}
+	// 按前缀遍历时回调也会收到内部节点，只处理叶子节点
+	art.tree.ForEachPrefix(start[:prefixLen], func(node goart.Node) bool {
+		if node.Kind() != goart.Leaf {
+			return true
+		}
+		key := node.Key()
+		if bytes.Compare(key, start) < 0 {
+			return true
+		}
+		if len(end) > 0 && bytes.Compare(key, end) >= 0 {
+			return false
+		}
+		return handleFn(key, node.Value().(*data.LogRecordPos))
+	})
+}
+
 // ART 索引迭代器
 type artIterator struct {
 	currIndex int     // 当前遍历的位置
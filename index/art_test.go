@@ -95,3 +95,7 @@ func Test_artIterator_Value(t *testing.T) {
 func Test_newArtIterator(t *testing.T) {
 
 }
+
+func TestAdaptiveRadixTree_AscendRange(t *testing.T) {
+	testAscendRange(t, NewART())
+}
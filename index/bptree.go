@@ -1,6 +1,7 @@
 package index
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/calmw/fdb/data"
 	"go.etcd.io/bbolt"
@@ -112,6 +113,25 @@ func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
 	return newBpTreeIterator(bpt.tree, reverse)
 }
 
+// AscendRange 按顺序遍历 [start, end) 区间内的key，游标直接定位到 start
+// key 只在只读事务中有效，handleFn 需要保存时必须拷贝
+func (bpt *BPlusTree) AscendRange(start, end []byte, handleFn func(key []byte, pos *data.LogRecordPos) bool) {
+	if err := bpt.tree.View(func(tx *bbolt.Tx) error {
+		cursor := tx.Bucket(bptreeBucketName).Cursor()
+		for key, value := cursor.Seek(start); key != nil; key, value = cursor.Next() {
+			if len(end) > 0 && bytes.Compare(key, end) >= 0 {
+				break
+			}
+			if !handleFn(key, data.DecodeLogRecordPos(value)) {
+				break
+			}
+		}
+		return nil
+	}); err != nil {
+		panic("failed to ascend range in bptree")
+	}
+}
+
 // ART 索引迭代器
 type bptreeIterator struct {
 	tx        *bbolt.Tx
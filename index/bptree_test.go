@@ -87,3 +87,15 @@ func TestBPlusTree_Iterator(t *testing.T) {
 		t.Log(string(iterator.Key()), iterator.Value())
 	}
 }
+
+func TestBPlusTree_AscendRange(t *testing.T) {
+	path := filepath.Join(os.TempDir())
+	defer func() {
+		_ = os.RemoveAll(filepath.Join(os.TempDir(), bptreeIndexFileName))
+	}()
+	tree := NewBPlusTree(path, true)
+	defer func() {
+		_ = tree.Close()
+	}()
+	testAscendRange(t, tree)
+}
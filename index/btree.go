@@ -64,6 +64,16 @@ func (bt *Btree) Close() error {
 	return nil
 }
 
+// Clone 复制一份索引，底层 btree 采用写时复制，复制的开销很小，复制后两份索引互不影响
+func (bt *Btree) Clone() *Btree {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return &Btree{
+		tree: bt.tree.Clone(),
+		lock: &sync.RWMutex{},
+	}
+}
+
 func (bt *Btree) Iterator(reverse bool) Iterator {
 	if bt.tree == nil {
 		return nil
@@ -73,6 +83,19 @@ func (bt *Btree) Iterator(reverse bool) Iterator {
 	return newBTreeIterator(bt.tree, reverse)
 }
 
+// AscendRange 按顺序遍历 [start, end) 区间内的key，只访问区间内的数据
+func (bt *Btree) AscendRange(start, end []byte, handleFn func(key []byte, pos *data.LogRecordPos) bool) {
+	bt.lock.RLock()
+	defer bt.lock.RUnlock()
+	bt.tree.AscendGreaterOrEqual(&Item{key: start}, func(it btree.Item) bool {
+		item := it.(*Item)
+		if len(end) > 0 && bytes.Compare(item.key, end) >= 0 {
+			return false
+		}
+		return handleFn(item.key, item.pos)
+	})
+}
+
 // BTree索引迭代器
 type btreeIterator struct {
 	currIndex int     // 当前遍历的位置
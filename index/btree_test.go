@@ -68,3 +68,55 @@ func TestBtree_Delete(t *testing.T) {
 	assert.NotNil(t, del2)
 	assert.True(t, ok)
 }
+
+func TestBtree_Clone(t *testing.T) {
+	bt := NewBtree()
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	bt.Put([]byte("b"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	clone := bt.Clone()
+	assert.Equal(t, 2, clone.Size())
+
+	// 修改原索引不影响复制的索引
+	bt.Put([]byte("a"), &data.LogRecordPos{Fid: 2, Offset: 30})
+	bt.Delete([]byte("b"))
+	bt.Put([]byte("c"), &data.LogRecordPos{Fid: 2, Offset: 40})
+
+	assert.Equal(t, int64(10), clone.Get([]byte("a")).Offset)
+	assert.NotNil(t, clone.Get([]byte("b")))
+	assert.Nil(t, clone.Get([]byte("c")))
+	assert.Equal(t, 2, clone.Size())
+	assert.Equal(t, int64(30), bt.Get([]byte("a")).Offset)
+}
+
+func TestBtree_AscendRange(t *testing.T) {
+	testAscendRange(t, NewBtree())
+}
+
+// 不同的索引按照同样的顺序返回区间内的key
+func testAscendRange(t *testing.T, indexer Indexer) {
+	for i, key := range []string{"c", "a", "abd", "b", "ab", "ba", "abc"} {
+		indexer.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+	ascend := func(start, end string, limit int) []string {
+		var keys []string
+		var endKey []byte
+		if end != "" {
+			endKey = []byte(end)
+		}
+		indexer.AscendRange([]byte(start), endKey, func(key []byte, pos *data.LogRecordPos) bool {
+			assert.NotNil(t, pos)
+			keys = append(keys, string(key))
+			return len(keys) < limit
+		})
+		return keys
+	}
+
+	assert.Equal(t, []string{"ab", "abc", "abd"}, ascend("ab", "b", 10))
+	assert.Equal(t, []string{"ab", "abc", "abd"}, ascend("ab", "ac", 10))
+	assert.Equal(t, []string{"abc"}, ascend("abc", "abd", 10))
+	assert.Equal(t, []string{"abd", "b", "ba", "c"}, ascend("abd", "", 10))
+	assert.Equal(t, []string{"a", "ab"}, ascend("a", "", 2))
+	assert.Empty(t, ascend("d", "", 10))
+	assert.Empty(t, ascend("bb", "c", 10))
+}
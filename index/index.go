@@ -14,6 +14,9 @@ type Indexer interface {
 	Size() int                                                 // 索引中存在多少条数据
 	Iterator(reverse bool) Iterator                            // 索引迭代器
 	Close() error                                              // 特别是B+树索引是需要关闭，它本身就是一个DB实例，其实btree和amt是不需要的
+	// AscendRange 按顺序遍历 [start, end) 区间内的key，end 为空表示没有终点，handleFn 返回 false 时停止
+	// 只访问区间内的数据，遍历时不能修改索引
+	AscendRange(start, end []byte, handleFn func(key []byte, pos *data.LogRecordPos) bool)
 }
 
 type IndexType = int8
@@ -3,22 +3,34 @@ package fdb
 import (
 	"bytes"
 	"github.com/calmw/fdb/index"
+	"time"
 )
 
 // Iterator 迭代器
 type Iterator struct {
-	indexIter index.Iterator
-	db        *DB
-	options   IteratorOptions
+	indexIter  index.Iterator
+	db         *DB
+	snapshot   *Snapshot // 不为空时表示快照上的迭代器，从快照中读取数据
+	options    IteratorOptions
+	blobPinned bool // 是否固定住了 blob 文件，关闭时需要释放
 }
 
-// NewIterator 初始化迭代器
+// NewIterator 初始化迭代器，使用完之后需要调用 Close 关闭
+// 不需要持有数据库的锁，可以在 Fold 的回调中使用
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
+	// 先固定住 blob 文件再获取索引迭代器，避免索引中的 blob 位置在读取之前被压缩
+	// 获取索引迭代器之后仍然没有 blob 文件，说明索引中没有 blob 位置，不需要固定
+	db.pinBlobFiles()
 	indexIter := db.index.Iterator(opts.Reverse)
+	blobPinned := db.hasBlobFiles()
+	if !blobPinned {
+		db.unpinBlobFiles()
+	}
 	return &Iterator{
-		indexIter: indexIter,
-		db:        db,
-		options:   opts,
+		indexIter:  indexIter,
+		db:         db,
+		options:    opts,
+		blobPinned: blobPinned,
 	}
 }
 
@@ -47,6 +59,9 @@ func (it *Iterator) Key() []byte {
 
 func (it *Iterator) Value() ([]byte, error) {
 	logRecordPos := it.indexIter.Value()
+	if it.snapshot != nil {
+		return it.snapshot.getValueByPosition(logRecordPos)
+	}
 	it.db.mu.RLock()
 	defer it.db.mu.RUnlock()
 	return it.db.getValueByPosition(logRecordPos)
@@ -54,17 +69,22 @@ func (it *Iterator) Value() ([]byte, error) {
 
 func (it *Iterator) Close() {
 	it.indexIter.Close()
+	if it.blobPinned {
+		it.blobPinned = false
+		it.db.unpinBlobFiles()
+	}
 }
 
-// 如果带前缀，需要跳转到下一个带有该前缀的项
+// 跳过已经过期的项，如果带前缀，需要跳转到下一个带有该前缀的项
 func (it *Iterator) skipToNext() {
 	perfixLen := len(it.options.Prefix)
-	if perfixLen == 0 {
-		return
-	}
+	now := time.Now().UnixNano()
 	for ; it.indexIter.Valid(); it.indexIter.Next() {
+		if it.indexIter.Value().IsExpired(now) {
+			continue
+		}
 		key := it.indexIter.Key()
-		if perfixLen <= len(key) && bytes.Compare(it.options.Prefix, key[:perfixLen]) == 0 {
+		if perfixLen == 0 || perfixLen <= len(key) && bytes.Compare(it.options.Prefix, key[:perfixLen]) == 0 {
 			break
 		}
 	}
@@ -1,7 +1,9 @@
 package fdb
 
 import (
+	"github.com/calmw/fdb/utils"
 	"github.com/stretchr/testify/assert"
+	"os"
 	"testing"
 )
 
@@ -59,3 +61,43 @@ func TestIterator_Value(t *testing.T) {
 func TestIterator_skipToNext(t *testing.T) {
 
 }
+
+func TestIterator_InsideFold(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-iterator-fold")
+	opts.DirPath = dir
+	opts.ValueThreshold = 128
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(16))
+		assert.Nil(t, err)
+	}
+	// 没有 blob 文件时，迭代器不会固定 blob 文件
+	iterator := db.NewIterator(DefaultIteratorOptions)
+	assert.False(t, iterator.blobPinned)
+	iterator.Close()
+
+	// Fold 的回调中创建、关闭迭代器不会死锁
+	for i := 10; i < 20; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(256))
+		assert.Nil(t, err)
+	}
+	var count int
+	err = db.Fold(func(key []byte, value []byte) bool {
+		iterator := db.NewIterator(IteratorOptions{Prefix: key})
+		assert.True(t, iterator.blobPinned)
+		iterator.Rewind()
+		assert.True(t, iterator.Valid())
+		assert.Equal(t, key, iterator.Key())
+		iterator.Close()
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 20, count)
+	assert.Equal(t, int32(0), db.blobPins)
+}
@@ -0,0 +1,40 @@
+package fdb
+
+import "time"
+
+// Listener 存储引擎事件的监听器
+// 回调在触发事件的协程中同步执行，部分回调执行时持有数据库的互斥锁，不能阻塞，也不能再调用数据库的方法
+// 只需要监听部分事件时可以嵌入 NopListener
+type Listener interface {
+	// OnSync 活跃文件持久化之后回调，包括 Sync、SyncWrite、BytesPerWrite、SyncInterval 以及切换活跃文件时的持久化
+	OnSync(fileId uint32)
+	// OnDataFileRotated 活跃文件写满或者 merge 开始时切换到新的活跃文件之后回调
+	OnDataFileRotated(oldFileId, newFileId uint32)
+	// OnMergeBegin Merge、MergeFiles 通过检查开始重写数据文件时回调
+	OnMergeBegin(info MergeInfo)
+	// OnMergeEnd Merge、MergeFiles 结束时回调，失败时 info.Err 不为空
+	OnMergeEnd(info MergeInfo)
+	// OnRecoveryProgress 打开数据库时每加载完一个数据文件回调一次，offset 为加载到的位置
+	OnRecoveryProgress(fileId uint32, offset int64)
+	// OnBackgroundError 后台的自动 merge、定时持久化以及跟随写入进程失败时回调
+	OnBackgroundError(err error)
+}
+
+// MergeInfo merge 的信息
+type MergeInfo struct {
+	FileIds        []uint32      // 被重写的数据文件
+	Selective      bool          // 是否是 MergeFiles 只重写部分数据文件
+	Duration       time.Duration // merge 的耗时，只在 OnMergeEnd 中有效
+	ReclaimedBytes int64         // merge 之后数据文件减少的字节数，只在 OnMergeEnd 中有效
+	Err            error         // merge 失败的原因，只在 OnMergeEnd 中有效
+}
+
+// NopListener 不处理任何事件的监听器，可以嵌入到只需要监听部分事件的监听器中
+type NopListener struct{}
+
+func (NopListener) OnSync(uint32)                    {}
+func (NopListener) OnDataFileRotated(uint32, uint32) {}
+func (NopListener) OnMergeBegin(MergeInfo)           {}
+func (NopListener) OnMergeEnd(MergeInfo)             {}
+func (NopListener) OnRecoveryProgress(uint32, int64) {}
+func (NopListener) OnBackgroundError(error)          {}
@@ -0,0 +1,123 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"sync"
+	"testing"
+)
+
+type testListener struct {
+	NopListener
+	mu         sync.Mutex
+	syncs      int
+	rotations  [][2]uint32
+	mergeBegin []MergeInfo
+	mergeEnd   []MergeInfo
+	recovered  map[uint32]int64
+}
+
+func (l *testListener) OnSync(fileId uint32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.syncs++
+}
+
+func (l *testListener) OnDataFileRotated(oldFileId, newFileId uint32) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rotations = append(l.rotations, [2]uint32{oldFileId, newFileId})
+}
+
+func (l *testListener) OnMergeBegin(info MergeInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mergeBegin = append(l.mergeBegin, info)
+}
+
+func (l *testListener) OnMergeEnd(info MergeInfo) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mergeEnd = append(l.mergeEnd, info)
+}
+
+func (l *testListener) OnRecoveryProgress(fileId uint32, offset int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recovered[fileId] = offset
+}
+
+func TestDB_Listener(t *testing.T) {
+	listener := &testListener{recovered: make(map[uint32]int64)}
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-listener")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.Listener = listener
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.写满活跃文件时切换到新的文件，切换之前持久化
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	assert.Greater(t, len(listener.rotations), 1)
+	for i, rotation := range listener.rotations {
+		assert.Equal(t, uint32(i), rotation[0])
+		assert.Equal(t, uint32(i+1), rotation[1])
+	}
+	assert.Equal(t, len(listener.rotations), listener.syncs)
+	err = db.Sync()
+	assert.Nil(t, err)
+	assert.Equal(t, len(listener.rotations)+1, listener.syncs)
+
+	// 2.merge 开始和结束
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	activeFileId := db.activeFile.FileId
+	rotations := len(listener.rotations)
+	err = db.Merge()
+	assert.Nil(t, err)
+	// merge 时切换了活跃文件，merge 使用的临时数据库不会触发事件
+	assert.Equal(t, rotations+1, len(listener.rotations))
+	assert.Equal(t, [2]uint32{activeFileId, activeFileId + 1}, listener.rotations[rotations])
+	assert.Equal(t, 1, len(listener.mergeBegin))
+	assert.Equal(t, 1, len(listener.mergeEnd))
+	assert.Equal(t, int(activeFileId)+1, len(listener.mergeBegin[0].FileIds))
+	assert.False(t, listener.mergeEnd[0].Selective)
+	assert.Nil(t, listener.mergeEnd[0].Err)
+	assert.Greater(t, listener.mergeEnd[0].Duration.Nanoseconds(), int64(0))
+	assert.Greater(t, listener.mergeEnd[0].ReclaimedBytes, int64(0))
+
+	// 3.merge 失败时也会回调 OnMergeEnd
+	err = db.MergeFiles([]uint32{0})
+	assert.Equal(t, ErrMergeNotApplied, err)
+	assert.Equal(t, 1, len(listener.mergeEnd))
+
+	// 4.重启时报告加载数据文件的进度
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.NotEmpty(t, listener.recovered)
+	offset, ok := listener.recovered[db2.activeFile.FileId]
+	assert.True(t, ok)
+	assert.Equal(t, db2.activeFile.WriteOff, offset)
+
+	// 5.MergeFiles
+	err = db2.MergeFiles([]uint32{0})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(listener.mergeEnd))
+	assert.True(t, listener.mergeEnd[1].Selective)
+	assert.Equal(t, []uint32{0}, listener.mergeEnd[1].FileIds)
+}
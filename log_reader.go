@@ -0,0 +1,258 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/data"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// LogPosition 日志中的位置，零值表示从最早的数据开始读取
+type LogPosition struct {
+	Fid        uint32 // 数据文件ID
+	Offset     int64  // 在数据文件中的偏移量
+	Generation uint32 // 数据文件被 MergeFiles、Repair 原地重写的次数，和当前的版本不同时位置已经失效
+}
+
+// ChangeRecord 从日志中读取到的一条变更记录
+type ChangeRecord struct {
+	Key      []byte
+	Value    []byte
+	Deleted  bool        // 是否是删除
+	RangeEnd []byte      // 范围删除时区间的终点（不包含），此时删除的是 [Key, RangeEnd) 区间内的所有key，为空表示没有终点
+	Ranged   bool        // 是否是范围删除
+	Expire   int64       // 过期时间，UnixNano，0表示永不过期
+	SeqNo    uint64      // 事务序列号，非事务的写入为0
+	Position LogPosition // 记录在日志中的位置
+}
+
+// LogReader 按照提交的顺序读取数据文件中的变更记录
+// WriteBatch、Txn 写入的记录只有在读到事务完成标识之后才会返回，事务完成标识本身不会返回
+type LogReader struct {
+	db        *DB
+	position  LogPosition     // 可以恢复读取的位置，之前的记录都已经完整返回
+	scanPos   LogPosition     // 当前扫描到的位置
+	pending   []*ChangeRecord // 已经提交，等待返回的事务记录
+	txSeqNo   uint64          // 正在暂存的事务序列号
+	txRecords []*ChangeRecord // 暂存的还没有读到完成标识的事务记录
+	txStart   LogPosition     // 暂存的事务的起始位置
+}
+
+// NewLogReader 从指定的位置开始读取变更记录，位置通常来自于之前的 LogReader.Position
+// merge 会重写旧的数据文件，如果指定的位置位于已经被 merge 的文件中，或者所在的文件之后被 MergeFiles、Repair 原地重写过，
+// 返回 ErrPositionCompacted，此时只能从零值位置重新开始读取（读取到的是 merge 之后的数据）
+func (db *DB) NewLogReader(from LogPosition) (*LogReader, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if from != (LogPosition{}) {
+		nonMergeFileId, err := db.mergedFileIdBound()
+		if err != nil {
+			return nil, err
+		}
+		if from.Fid < nonMergeFileId || from.Generation != db.rewrittenFiles[from.Fid] {
+			return nil, ErrPositionCompacted
+		}
+		dataFile := db.dataFileById(from.Fid)
+		if dataFile == nil {
+			if db.activeFile != nil && from.Fid < db.activeFile.FileId {
+				return nil, ErrPositionCompacted
+			}
+			return nil, ErrInvalidLogPosition
+		}
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return nil, err
+		}
+		if from.Offset > size {
+			return nil, ErrInvalidLogPosition
+		}
+	} else if fileIds := db.sortedFileIds(); len(fileIds) > 0 {
+		from.Fid = fileIds[0]
+		from.Generation = db.rewrittenFiles[from.Fid]
+	}
+
+	return &LogReader{
+		db:       db,
+		position: from,
+		scanPos:  from,
+	}, nil
+}
+
+// Position 返回可以恢复读取的位置，使用该位置创建新的 LogReader 可以接着读取
+// 事务的记录没有全部返回之前，位置停留在事务的起始位置，恢复之后会重新返回整个事务
+func (r *LogReader) Position() LogPosition {
+	return r.position
+}
+
+// Next 读取下一条变更记录，没有更多已提交的记录时返回 io.EOF，之后有新的写入可以继续调用
+func (r *LogReader) Next() (*ChangeRecord, error) {
+	for {
+		if len(r.pending) > 0 {
+			record := r.pending[0]
+			r.pending = r.pending[1:]
+			if len(r.pending) == 0 {
+				r.position = r.scanPos
+			}
+			return record, nil
+		}
+
+		logRecord, size, err := r.readAt(r.scanPos)
+		if err == io.EOF {
+			next, ok, err := r.nextFile()
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, io.EOF
+			}
+			r.scanPos = next
+			if len(r.txRecords) == 0 {
+				r.position = next
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		recordPos := r.scanPos
+		r.scanPos.Offset += size
+		realKey, seqNo := parseLogRecordKey(logRecord.Key)
+
+		// 非事务的记录，之前暂存的事务没有完成标识，说明事务没有提交成功，直接丢弃
+		if seqNo == nonTransactionSeqNo {
+			r.txRecords = nil
+			r.position = r.scanPos
+			if logRecord.Type == data.LogRecordRangeDeleted {
+				return &ChangeRecord{
+					Key:      realKey,
+					Deleted:  true,
+					RangeEnd: logRecord.Value,
+					Ranged:   true,
+					SeqNo:    seqNo,
+					Position: recordPos,
+				}, nil
+			}
+			return &ChangeRecord{
+				Key:      realKey,
+				Value:    logRecord.Value,
+				Deleted:  logRecord.Type == data.LogRecordDeleted,
+				Expire:   logRecord.Expire,
+				SeqNo:    seqNo,
+				Position: recordPos,
+			}, nil
+		}
+
+		if seqNo != r.txSeqNo || len(r.txRecords) == 0 {
+			r.txRecords = nil
+			r.txSeqNo = seqNo
+			r.txStart = recordPos
+		}
+		if logRecord.Type == data.LogRecordTxFinished {
+			r.pending = r.txRecords
+			r.txRecords = nil
+			if len(r.pending) == 0 {
+				r.position = r.scanPos
+			}
+			continue
+		}
+		r.txRecords = append(r.txRecords, &ChangeRecord{
+			Key:      realKey,
+			Value:    logRecord.Value,
+			Deleted:  logRecord.Type == data.LogRecordDeleted,
+			Expire:   logRecord.Expire,
+			SeqNo:    seqNo,
+			Position: recordPos,
+		})
+		r.position = r.txStart
+	}
+}
+
+// 读取指定位置的记录
+func (r *LogReader) readAt(pos LogPosition) (*data.LogRecord, int64, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	dataFile := r.db.dataFileById(pos.Fid)
+	if dataFile == nil {
+		if r.db.activeFile == nil {
+			return nil, 0, io.EOF
+		}
+		if pos.Fid < r.db.activeFile.FileId {
+			return nil, 0, ErrPositionCompacted
+		}
+		return nil, 0, io.EOF
+	}
+	// 只读模式下重新加载了数据目录，文件已经被原地重写
+	if pos.Generation != r.db.rewrittenFiles[pos.Fid] {
+		return nil, 0, ErrPositionCompacted
+	}
+	// 活跃文件只读取到已经写入的位置
+	if dataFile == r.db.activeFile && pos.Offset >= dataFile.WriteOff {
+		return nil, 0, io.EOF
+	}
+	logRecord, size, err := dataFile.ReadLogRecord(pos.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	// value 分离存储的记录，从 blob 文件中读取实际的 value
+	// blob 文件已经被压缩时，说明这是一条历史记录，其 value 被重新写入到了之后的记录中，此时 value 为空
+	if logRecord.Type == data.LogRecordBlobRef {
+		blobPos := &data.LogRecordPos{Blob: data.DecodeLogRecordPos(logRecord.Value)}
+		value, err := getValueFromBlobFile(r.db.blobFileById(blobPos.Blob.Fid), blobPos)
+		if err != nil && err != ErrDataFileNotFound {
+			return nil, 0, err
+		}
+		logRecord.Value = value
+	}
+	return logRecord, size, nil
+}
+
+// 当前文件已经读完，找到下一个数据文件
+func (r *LogReader) nextFile() (LogPosition, bool, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	for _, fid := range r.db.sortedFileIds() {
+		if fid > r.scanPos.Fid {
+			return LogPosition{Fid: fid, Generation: r.db.rewrittenFiles[fid]}, true, nil
+		}
+	}
+	return LogPosition{}, false, nil
+}
+
+// 根据文件ID找到数据文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) dataFileById(fid uint32) *data.DataFile {
+	if db.activeFile != nil && db.activeFile.FileId == fid {
+		return db.activeFile
+	}
+	return db.olderFiles[fid]
+}
+
+// 从小到大排序的所有数据文件ID
+// 在访问此方法前必须持有互斥锁
+func (db *DB) sortedFileIds() []uint32 {
+	fileIds := make([]uint32, 0, len(db.olderFiles)+1)
+	for fid := range db.olderFiles {
+		fileIds = append(fileIds, fid)
+	}
+	if db.activeFile != nil {
+		fileIds = append(fileIds, db.activeFile.FileId)
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+	return fileIds
+}
+
+// 数据目录中被 merge 重写过的文件ID上界，小于该值的文件都是 merge 生成的，没有发生过 merge 返回0
+func (db *DB) mergedFileIdBound() (uint32, error) {
+	mergeFinishedFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(mergeFinishedFileName); os.IsNotExist(err) {
+		return 0, nil
+	}
+	return db.getNonMergeFileId(db.options.DirPath)
+}
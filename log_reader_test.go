@@ -0,0 +1,201 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestDB_NewLogReader(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-log-reader")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 空的数据库
+	reader, err := db.NewLogReader(LogPosition{})
+	assert.Nil(t, err)
+	_, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	err = db.Delete(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put(utils.GetTestKey(200), []byte("batch"))
+	_ = wb.Delete(utils.GetTestKey(1))
+	err = wb.Commit()
+	assert.Nil(t, err)
+	assert.Greater(t, len(db.olderFiles), 0)
+
+	// 按照提交的顺序读取，跨越多个数据文件，事务完成标识不返回
+	var records []*ChangeRecord
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		records = append(records, record)
+	}
+	assert.Equal(t, 103, len(records))
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, utils.GetTestKey(i), records[i].Key)
+		assert.False(t, records[i].Deleted)
+	}
+	assert.Equal(t, utils.GetTestKey(0), records[100].Key)
+	assert.True(t, records[100].Deleted)
+	assert.Greater(t, records[101].SeqNo, nonTransactionSeqNo)
+	assert.Equal(t, records[101].SeqNo, records[102].SeqNo)
+
+	// 之后的写入可以继续读取
+	err = db.Put(utils.GetTestKey(300), []byte("tail"))
+	assert.Nil(t, err)
+	record, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(300), record.Key)
+	assert.Equal(t, []byte("tail"), record.Value)
+
+	// 从记录的位置恢复读取
+	position := reader.Position()
+	err = db.Put(utils.GetTestKey(301), []byte("resume"))
+	assert.Nil(t, err)
+	reader2, err := db.NewLogReader(position)
+	assert.Nil(t, err)
+	record, err = reader2.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(301), record.Key)
+	_, err = reader2.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDB_NewLogReader_Compacted(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-log-reader-compacted")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	reader, err := db.NewLogReader(LogPosition{})
+	assert.Nil(t, err)
+	_, err = reader.Next()
+	assert.Nil(t, err)
+	position := reader.Position()
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+
+	// merge 之后旧的位置已经失效
+	_, err = db2.NewLogReader(position)
+	assert.Equal(t, ErrPositionCompacted, err)
+
+	// 从零值位置开始可以读取 merge 之后的数据
+	reader2, err := db2.NewLogReader(LogPosition{})
+	assert.Nil(t, err)
+	var count int
+	for {
+		_, err := reader2.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		count++
+	}
+	assert.Equal(t, 100, count)
+}
+
+func TestDB_NewLogReader_MergeFiles(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-log-reader-merge-files")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(64))
+		assert.Nil(t, err)
+	}
+	// 0号文件中的数据都被删除，MergeFiles 之后文件变小
+	for i := 0; i < 20; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	reader, err := db.NewLogReader(LogPosition{})
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		_, err = reader.Next()
+		assert.Nil(t, err)
+	}
+	position := reader.Position()
+	assert.Equal(t, uint32(0), position.Fid)
+	assert.Greater(t, position.Offset, int64(0))
+	// 没有被重写的文件中的位置
+	var otherPosition LogPosition
+	for otherPosition.Fid == 0 {
+		_, err = reader.Next()
+		assert.Nil(t, err)
+		otherPosition = reader.Position()
+	}
+
+	err = db.MergeFiles([]uint32{0})
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+
+	// 原地重写的文件中旧的位置已经失效，其他文件中的位置仍然有效
+	_, err = db2.NewLogReader(position)
+	assert.Equal(t, ErrPositionCompacted, err)
+	_, err = db2.NewLogReader(otherPosition)
+	assert.Nil(t, err)
+
+	// 重写之后读取到的位置可以继续读取
+	reader2, err := db2.NewLogReader(LogPosition{})
+	assert.Nil(t, err)
+	record, err := reader2.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), record.Position.Fid)
+	assert.Equal(t, uint32(1), record.Position.Generation)
+	position2 := reader2.Position()
+	next, err := reader2.Next()
+	assert.Nil(t, err)
+
+	err = db2.Close()
+	assert.Nil(t, err)
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db3.Close()
+	}()
+	reader3, err := db3.NewLogReader(position2)
+	assert.Nil(t, err)
+	record, err = reader3.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, next, record)
+}
@@ -1,7 +1,9 @@
 package fdb
 
 import (
+	"context"
 	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/fio"
 	"github.com/calmw/fdb/utils"
 	"io"
 	"os"
@@ -9,6 +11,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"time"
 )
 
 const (
@@ -18,14 +21,35 @@ const (
 
 // Merge 清理无效数据文件
 func (db *DB) Merge() error {
+	return db.MergeContext(context.Background())
+}
+
+// MergeContext 和 Merge 相同，ctx 取消时停止重写数据文件并返回 ctx.Err()
+// 取消或者失败时会删除 merge 目录，下次启动时不会加载没有完成的 merge 结果
+func (db *DB) MergeContext(ctx context.Context) (err error) {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if db.activeFile == nil { // 如果数据库为空，则直接返回
 		return nil
 	}
+	start := time.Now()
 	db.mu.Lock()
 	if db.isMerging { // 如果正在进行当中，则直接返回
 		db.mu.Unlock()
 		return ErrMergeIsProgress
 	}
+	// MergeFiles 的结果还没有生效，merge 目录不能被覆盖
+	if _, err := os.Stat(filepath.Join(db.getMergePath(), data.MergeFinishedFileName)); err == nil {
+		db.mu.Unlock()
+		return ErrMergeNotApplied
+	}
+
+	// 过期key占用的空间也是可以回收的
+	db.removeExpiredKeys()
 
 	// 检查是否达到了可以merge的阀值
 	totalSize, err := utils.DirSize(db.options.DirPath)
@@ -33,6 +57,13 @@ func (db *DB) Merge() error {
 		db.mu.Unlock()
 		return err
 	}
+	// blob 文件不参与merge，由 CompactBlobs 单独回收
+	blobSize, err := db.blobFilesSize()
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	totalSize -= blobSize
 	if float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
 		db.mu.Unlock()
 		return ErrMergeRatioUnreached
@@ -59,21 +90,30 @@ func (db *DB) Merge() error {
 		return err
 	}
 	// 将当前活跃文件，转化为旧的数据文件
-	db.olderFiles[db.activeFile.FileId] = db.activeFile
+	oldFileId := db.activeFile.FileId
+	db.olderFiles[oldFileId] = db.activeFile
 	// 打开新的活跃文件
 	if err := db.setActiveDataFile(); err != nil {
 		db.mu.Unlock()
 		return nil
 	}
+	db.listener.OnDataFileRotated(oldFileId, db.activeFile.FileId)
 
 	// 记录最近没有参与merge的文件id
 	nonMergFileId := db.activeFile.FileId
 
 	// 取出所有需要merge的文件
 	var mergeFiles []*data.DataFile
+	var mergeFilesSize int64
 
 	for _, file := range db.olderFiles {
 		mergeFiles = append(mergeFiles, file)
+		size, err := file.IoManager.Size()
+		if err != nil {
+			db.mu.Unlock()
+			return err
+		}
+		mergeFilesSize += size
 	}
 	db.mu.Unlock()
 
@@ -82,6 +122,17 @@ func (db *DB) Merge() error {
 		return mergeFiles[i].FileId < mergeFiles[j].FileId
 	})
 
+	info := MergeInfo{FileIds: make([]uint32, 0, len(mergeFiles))}
+	for _, file := range mergeFiles {
+		info.FileIds = append(info.FileIds, file.FileId)
+	}
+	db.listener.OnMergeBegin(info)
+	defer func() {
+		info.Duration = time.Since(start)
+		info.Err = err
+		db.listener.OnMergeEnd(info)
+	}()
+
 	mergePath := db.getMergePath()
 	// 如果目录存在，说明发生过merge，将其删掉
 	if _, err := os.Stat(mergePath); err != nil {
@@ -93,23 +144,46 @@ func (db *DB) Merge() error {
 	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
 		return err
 	}
+	// 失败或者取消时删除 merge 目录，此时还没有写标识 merge 完成的文件
+	var mergeDB *DB
+	var hintFile *data.DataFile
+	defer func() {
+		if err == nil {
+			return
+		}
+		if hintFile != nil {
+			_ = hintFile.Close()
+		}
+		if mergeDB != nil {
+			_ = mergeDB.Close()
+		}
+		_ = os.RemoveAll(mergePath)
+	}()
 	// 打开一个新的db实例
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrite = false
-	mergeDB, err := Open(mergeOptions)
+	mergeOptions.AutoMerge.Enable = false
+	mergeOptions.SyncInterval = 0
+	mergeOptions.Listener = nil
+	mergeDB, err = Open(mergeOptions)
 	if err != nil {
 		return err
 	}
 	// 打开hint文件存储索引
-	hintFile, err := data.OpenHintFile(mergePath)
+	hintFile, err = data.OpenHintFile(mergePath, fio.StandardFIO)
 	if err != nil {
 		return err
 	}
+	hintFile.Cipher = db.cipher
 	// 遍历处理每个数据文件
+	now := time.Now().UnixNano()
 	for _, dataFile := range mergeFiles {
 		var offset int64
 		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			logRecord, size, err := dataFile.ReadLogRecord(offset)
 			if err != nil {
 				if err == io.EOF {
@@ -119,9 +193,13 @@ func (db *DB) Merge() error {
 			}
 			// 解析拿到实际的key
 			realKey, _ := parseLogRecordKey(logRecord.Key)
+			// 和内存索引位置进行比较，如果有效且未过期则重写，merge 期间可能有并发的写入，需要持有读锁
+			db.mu.RLock()
 			logRecordPos := db.index.Get(realKey)
-			// 和内存索引位置进行比较，如果有效则重写
-			if logRecordPos != nil && logRecordPos.Fid == dataFile.FileId && logRecordPos.Offset == offset {
+			valid := logRecordPos != nil && logRecordPos.Fid == dataFile.FileId && logRecordPos.Offset == offset &&
+				!logRecordPos.IsExpired(now)
+			db.mu.RUnlock()
+			if valid {
 				// 清除事务标记
 				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
 				pos, err := mergeDB.appendLogRecord(logRecord)
@@ -148,24 +226,29 @@ func (db *DB) Merge() error {
 	}
 
 	// 写标识merge完成的文件
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath, fio.StandardFIO)
 	if err != nil {
 		return err
 	}
+	mergeFinishedFile.Cipher = db.cipher
 	mergeFinishedRecord := &data.LogRecord{
 		Key:   []byte(mergeFinishedKey),
 		Value: []byte(strconv.Itoa(int(nonMergFileId))),
 		//Type:  0, // 默认值0 普通类型
 	}
 
-	encRecord, _ := data.EncodeLogRecord(mergeFinishedRecord)
-	if err = mergeFinishedFile.Write(encRecord); err != nil {
+	if err = mergeFinishedFile.WriteLogRecord(mergeFinishedRecord); err != nil {
 		return err
 	}
 	if err = mergeFinishedFile.Sync(); err != nil {
 		return err
 	}
 
+	// 重写之后的数据文件减少的大小就是 merge 回收的空间，统计失败不影响 merge 的结果
+	if mergedSize, err := dataFilesSize(mergePath); err == nil {
+		info.ReclaimedBytes = mergeFilesSize - mergedSize
+	}
+	db.metrics.observeMerge(start, info.ReclaimedBytes)
 	return nil
 }
 
@@ -182,8 +265,12 @@ func (db *DB) loadMergeFiles() error {
 	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
 		return nil
 	}
+	// MergeFiles 的结果替换到一半失败时保留 merge 目录，下次打开时重新替换
+	var keepMergeDir bool
 	defer func() {
-		_ = os.RemoveAll(mergePath)
+		if !keepMergeDir {
+			_ = os.RemoveAll(mergePath)
+		}
 	}()
 	dirEntries, err := os.ReadDir(mergePath)
 	if err != nil {
@@ -211,12 +298,29 @@ func (db *DB) loadMergeFiles() error {
 		return nil
 	}
 
+	// 只重写了部分数据文件，直接替换原来的文件
+	mergeFilesFinished, err := db.isMergeFilesFinished(mergePath)
+	if err != nil {
+		return err
+	}
+	if mergeFilesFinished {
+		if err = db.applyMergeFiles(mergePath, mergeFileNames); err != nil {
+			keepMergeDir = true
+		}
+		return err
+	}
+
 	//
 	nonMergedFileId, err := db.getNonMergeFileId(mergePath)
 	if err != nil {
 		return nil
 	}
 
+	// 索引检查点中的位置指向旧的数据文件，需要删除，之后从hint文件重新加载
+	if err = removeIndexCheckpoint(db.options.DirPath); err != nil {
+		return err
+	}
+
 	// 删除旧的数据文件,删除小于nonMergedFileId的文件
 	var fileId uint32
 	for ; fileId < nonMergedFileId; fileId++ {
@@ -241,10 +345,14 @@ func (db *DB) loadMergeFiles() error {
 }
 
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath, fio.ReadOnlyFIO)
 	if err != nil {
 		return 0, err
 	}
+	defer func() {
+		_ = mergeFinishedFile.Close()
+	}()
+	mergeFinishedFile.Cipher = db.cipher
 	record, _, err := mergeFinishedFile.ReadLogRecord(0) // 只有一条数据，所以offset是0
 	if err != nil {
 		return 0, err
@@ -258,20 +366,25 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 }
 
 // 从hint文件中加载索引
-func (db *DB) loadIndexFromHintFile() error {
+func (db *DB) loadIndexFromHintFile(ctx context.Context) error {
 	// 查看hint索引文件是否存在
 	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
 	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
 		return nil
 	}
 	// 打开hint索引文件
-	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	hintFile, err := data.OpenHintFile(db.options.DirPath, fio.ReadOnlyFIO)
 	if err != nil {
 		return err
 	}
+	hintFile.Cipher = db.cipher
 	// 读取文件中的索引
 	var offset int64
+	now := time.Now().UnixNano()
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		logRecord, size, err := hintFile.ReadLogRecord(offset)
 		if err != nil {
 			if err == io.EOF {
@@ -280,7 +393,11 @@ func (db *DB) loadIndexFromHintFile() error {
 			return err
 		}
 		logRecordPos := data.DecodeLogRecordPos(logRecord.Value)
-		db.index.Put(logRecord.Key, logRecordPos)
+		if logRecordPos.IsExpired(now) { // 已经过期的key不再加载，计入无效数据大小
+			db.reclaimPos(logRecordPos)
+		} else {
+			db.index.Put(logRecord.Key, logRecordPos)
+		}
 		offset += size
 	}
 
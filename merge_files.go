@@ -0,0 +1,427 @@
+package fdb
+
+import (
+	"encoding/binary"
+	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/fio"
+	"github.com/calmw/fdb/utils"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mergeFilesFinishedKey = "merge.files.finished"
+	rewrittenFilesKey     = "rewritten.files"
+	rewrittenFilesTmpExt  = ".tmp"
+)
+
+// merge 时被移动了位置的有效数据
+type mergeFilesMove struct {
+	oldOffset int64
+	newPos    *data.LogRecordPos
+}
+
+// 增加数据文件中的无效数据大小
+// 在访问此方法前必须持有互斥锁
+func (db *DB) addReclaimSize(fid uint32, size int64) {
+	db.reclaimSize += size
+	db.fileGarbage[fid] += size
+}
+
+// PickMergeFiles 选出无效数据占比达到 DataFileGarbageRatio 的旧数据文件，可以直接传给 MergeFiles
+func (db *DB) PickMergeFiles() []uint32 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 过期的key占用的空间也是可以回收的
+	db.removeExpiredKeys()
+
+	var fids []uint32
+	for fid, dataFile := range db.olderFiles {
+		size, err := dataFile.IoManager.Size()
+		if err != nil || size == 0 {
+			continue
+		}
+		if float32(db.fileGarbage[fid])/float32(size) >= db.options.DataFileGarbageRatio {
+			fids = append(fids, fid)
+		}
+	}
+	sort.Slice(fids, func(i, j int) bool {
+		return fids[i] < fids[j]
+	})
+	return fids
+}
+
+// MergeFiles 只重写指定的旧数据文件，去掉其中的无效数据，其他数据文件保持不变
+// 重写之后的文件和原来的文件ID相同，记录的先后顺序也不变，在下次打开数据库时替换原来的文件
+// 仍然可能遮盖其他文件中旧数据的删除标识会被保留
+func (db *DB) MergeFiles(fids []uint32) (err error) {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if len(fids) == 0 {
+		return nil
+	}
+	start := time.Now()
+	db.mu.Lock()
+	if db.isMerging { // 如果正在进行当中，则直接返回
+		db.mu.Unlock()
+		return ErrMergeIsProgress
+	}
+	// 上一次 merge 的结果还没有生效
+	mergePath := db.getMergePath()
+	if _, err := os.Stat(mergePath); err == nil {
+		db.mu.Unlock()
+		return ErrMergeNotApplied
+	}
+
+	// 过期key占用的空间也是可以回收的
+	db.removeExpiredKeys()
+
+	// 只能 merge 旧的数据文件，活跃文件还在写入
+	mergeFiles := make(map[uint32]*data.DataFile, len(fids))
+	var liveSize, mergeFilesSize int64
+	for _, fid := range fids {
+		dataFile, ok := db.olderFiles[fid]
+		if !ok {
+			db.mu.Unlock()
+			return ErrInvalidMergeFile
+		}
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			db.mu.Unlock()
+			return err
+		}
+		if _, ok := mergeFiles[fid]; !ok {
+			liveSize += size - db.fileGarbage[fid]
+			mergeFilesSize += size
+		}
+		mergeFiles[fid] = dataFile
+	}
+	// 查看剩余空间是否可以容纳merge后的数据量
+	availableDiskSize, err := utils.AvailableDiskSize()
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	if uint64(liveSize) >= availableDiskSize {
+		db.mu.Unlock()
+		return ErrNotEnoughSpaceForMerge
+	}
+	// 小于 nonMergeFileId 的文件的索引是从 hint 文件加载的，重写之后需要重新生成 hint 文件
+	nonMergeFileId, err := db.mergedFileIdBound()
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+
+	db.isMerging = true
+	defer func() {
+		db.isMerging = false
+	}()
+	db.mu.Unlock()
+
+	info := MergeInfo{FileIds: make([]uint32, 0, len(mergeFiles)), Selective: true}
+	for fid := range mergeFiles {
+		info.FileIds = append(info.FileIds, fid)
+	}
+	sort.Slice(info.FileIds, func(i, j int) bool {
+		return info.FileIds[i] < info.FileIds[j]
+	})
+	db.listener.OnMergeBegin(info)
+	defer func() {
+		info.Duration = time.Since(start)
+		info.Err = err
+		db.listener.OnMergeEnd(info)
+	}()
+
+	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
+		return err
+	}
+	moves := make(map[string]*mergeFilesMove)
+	for fid, dataFile := range mergeFiles {
+		if err := db.rewriteDataFile(mergePath, dataFile, fid < nonMergeFileId, moves); err != nil {
+			return err
+		}
+	}
+
+	if hasFileIdBelow(mergeFiles, nonMergeFileId) {
+		if err := db.writeMergeFilesHint(mergePath, nonMergeFileId, mergeFiles, moves); err != nil {
+			return err
+		}
+	}
+
+	// 写标识merge完成的文件，和 Merge 的区别是 key 不同，值为被重写的文件数量
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = mergeFinishedFile.Close()
+	}()
+	mergeFinishedFile.Cipher = db.cipher
+	mergeFinishedRecord := &data.LogRecord{
+		Key:   []byte(mergeFilesFinishedKey),
+		Value: []byte(strconv.Itoa(len(mergeFiles))),
+	}
+	if err = mergeFinishedFile.WriteLogRecord(mergeFinishedRecord); err != nil {
+		return err
+	}
+	if err = mergeFinishedFile.Sync(); err != nil {
+		return err
+	}
+
+	// 重写之后的数据文件减少的大小就是 merge 回收的空间，统计失败不影响 merge 的结果
+	if mergedSize, err := dataFilesSize(mergePath); err == nil {
+		info.ReclaimedBytes = mergeFilesSize - mergedSize
+	}
+	db.metrics.observeMerge(start, info.ReclaimedBytes)
+	return nil
+}
+
+// 将数据文件中的有效数据按原来的顺序重写到 merge 目录中的同名文件
+// 有效数据去掉事务标记；key 已经不存在时，删除标识（过期的数据转换为删除标识）需要保留，避免其他文件中的旧数据在重启后重新生效
+// trackMoves 为 true 时记录有效数据的新位置，用于重新生成 hint 文件
+func (db *DB) rewriteDataFile(mergePath string, dataFile *data.DataFile, trackMoves bool, moves map[string]*mergeFilesMove) error {
+	output, err := data.OpenDataFile(mergePath, dataFile.FileId, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = output.Close()
+	}()
+
+	var offset int64
+	now := time.Now().UnixNano()
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		db.mu.RLock()
+		logRecordPos := db.index.Get(realKey)
+		db.mu.RUnlock()
+
+		var record *data.LogRecord
+		switch {
+		case logRecord.Type == data.LogRecordRangeDeleted:
+			record = logRecord
+		case logRecord.Type == data.LogRecordTxFinished:
+		case logRecordPos != nil:
+			if logRecordPos.Fid == dataFile.FileId && logRecordPos.Offset == offset && !logRecordPos.IsExpired(now) {
+				record = logRecord
+			}
+		case logRecord.Type == data.LogRecordDeleted || logRecord.Expire > 0 && logRecord.Expire <= now:
+			record = &data.LogRecord{Type: data.LogRecordDeleted}
+		}
+		if record != nil {
+			record.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNo)
+			encRecord, newSize := db.encodeLogRecord(record)
+			newPos := &data.LogRecordPos{Fid: output.FileId, Offset: output.WriteOff, Size: uint32(newSize)}
+			if err := output.Write(encRecord); err != nil {
+				return err
+			}
+			if trackMoves && logRecordPos != nil {
+				newPos.Expire = logRecordPos.Expire
+				newPos.Blob = logRecordPos.Blob
+				moves[string(realKey)] = &mergeFilesMove{oldOffset: offset, newPos: newPos}
+			}
+		}
+		offset += size
+	}
+
+	return output.Sync()
+}
+
+// 根据当前的内存索引重新生成 hint 文件，只包含位于小于 nonMergeFileId 的文件中的数据
+func (db *DB) writeMergeFilesHint(mergePath string, nonMergeFileId uint32, mergeFiles map[uint32]*data.DataFile,
+	moves map[string]*mergeFilesMove) error {
+	hintFile, err := data.OpenHintFile(mergePath, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = hintFile.Close()
+	}()
+	hintFile.Cipher = db.cipher
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		if pos.Fid >= nonMergeFileId {
+			continue
+		}
+		if _, ok := mergeFiles[pos.Fid]; ok {
+			move := moves[string(iterator.Key())]
+			if move == nil || move.oldOffset != pos.Offset {
+				continue
+			}
+			pos = move.newPos
+		}
+		if err := hintFile.WriteHintRecord(iterator.Key(), pos); err != nil {
+			return err
+		}
+	}
+	return hintFile.Sync()
+}
+
+func hasFileIdBelow(files map[uint32]*data.DataFile, bound uint32) bool {
+	for fid := range files {
+		if fid < bound {
+			return true
+		}
+	}
+	return false
+}
+
+// merge 目录中的 merge 完成标识是否是由 MergeFiles 写入的
+func (db *DB) isMergeFilesFinished(dirPath string) (bool, error) {
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath, fio.ReadOnlyFIO)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = mergeFinishedFile.Close()
+	}()
+	mergeFinishedFile.Cipher = db.cipher
+	record, _, err := mergeFinishedFile.ReadLogRecord(0)
+	if err != nil {
+		return false, err
+	}
+	return string(record.Key) == mergeFilesFinishedKey, nil
+}
+
+// 用 MergeFiles 重写之后的文件替换原来的文件，数据目录中原有的 merge 完成标识保持不变
+// merge 完成标识留在 merge 目录中，失败之后重新执行时只会替换剩下的文件
+func (db *DB) applyMergeFiles(mergePath string, fileNames []string) error {
+	// 索引检查点中的位置指向原来的文件
+	if err := removeIndexCheckpoint(db.options.DirPath); err != nil {
+		return err
+	}
+	// 替换之前先增加被重写的文件的版本，之前保存的这些文件中的日志位置不再有效
+	var fids []uint32
+	for _, fileName := range fileNames {
+		if !strings.HasSuffix(fileName, data.DataFileNameSuffix) {
+			continue
+		}
+		fid, err := strconv.Atoi(strings.TrimSuffix(fileName, data.DataFileNameSuffix))
+		if err != nil {
+			return ErrDataDirectoryCorrupted
+		}
+		fids = append(fids, uint32(fid))
+	}
+	if err := addRewrittenFiles(db.options.DirPath, db.cipher, fids); err != nil {
+		return err
+	}
+	for _, fileName := range fileNames {
+		if fileName == data.MergeFinishedFileName {
+			continue
+		}
+		if err := os.Rename(filepath.Join(mergePath, fileName), filepath.Join(db.options.DirPath, fileName)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 增加原地重写过的数据文件的版本，和数据目录中已有的记录合并，先写临时文件再重命名，避免崩溃时留下不完整的文件
+func addRewrittenFiles(dirPath string, cipher *data.Cipher, fids []uint32) error {
+	if len(fids) == 0 {
+		return nil
+	}
+	rewrittenFiles, err := loadRewrittenFiles(dirPath, cipher)
+	if err != nil {
+		return err
+	}
+	for _, fid := range fids {
+		rewrittenFiles[fid]++
+	}
+	sortedFids := make([]uint32, 0, len(rewrittenFiles))
+	for fid := range rewrittenFiles {
+		sortedFids = append(sortedFids, fid)
+	}
+	sort.Slice(sortedFids, func(i, j int) bool {
+		return sortedFids[i] < sortedFids[j]
+	})
+	var value []byte
+	for _, fid := range sortedFids {
+		value = binary.AppendUvarint(value, uint64(fid))
+		value = binary.AppendUvarint(value, uint64(rewrittenFiles[fid]))
+	}
+	logRecord := &data.LogRecord{Key: []byte(rewrittenFilesKey), Value: value}
+	if cipher != nil {
+		logRecord = data.EncryptLogRecord(logRecord, cipher)
+	}
+	encRecord, _ := data.EncodeLogRecord(logRecord)
+
+	fileName := filepath.Join(dirPath, data.RewrittenFilesFileName)
+	tmpFileName := fileName + rewrittenFilesTmpExt
+	file, err := os.OpenFile(tmpFileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(tmpFileName)
+	}()
+	if _, err = file.Write(encRecord); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
+// 读取原地重写过的数据文件及其版本，文件不存在时返回空，没有被重写过的文件版本为0
+func loadRewrittenFiles(dirPath string, cipher *data.Cipher) (map[uint32]uint32, error) {
+	rewrittenFiles := make(map[uint32]uint32)
+	if _, err := os.Stat(filepath.Join(dirPath, data.RewrittenFilesFileName)); os.IsNotExist(err) {
+		return rewrittenFiles, nil
+	}
+	rewrittenFile, err := data.OpenRewrittenFilesFile(dirPath, fio.ReadOnlyFIO)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rewrittenFile.Close()
+	}()
+	rewrittenFile.Cipher = cipher
+	record, _, err := rewrittenFile.ReadLogRecord(0)
+	if err != nil {
+		return nil, err
+	}
+	if string(record.Key) != rewrittenFilesKey {
+		return nil, ErrDataDirectoryCorrupted
+	}
+	value := record.Value
+	for len(value) > 0 {
+		fid, n := binary.Uvarint(value)
+		if n <= 0 {
+			return nil, ErrDataDirectoryCorrupted
+		}
+		value = value[n:]
+		generation, n := binary.Uvarint(value)
+		if n <= 0 {
+			return nil, ErrDataDirectoryCorrupted
+		}
+		value = value[n:]
+		rewrittenFiles[uint32(fid)] = uint32(generation)
+	}
+	return rewrittenFiles, nil
+}
@@ -0,0 +1,188 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_MergeFiles(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-merge-files")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	// 删除标识和被删除的数据位于不同的文件中
+	for i := 0; i < 50; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	values := make(map[int][]byte)
+	for i := 100; i < 300; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+
+	// 1.统计每个数据文件中的无效数据
+	stat := db.Stat()
+	var total int64
+	for _, size := range stat.DataFileReclaimSize {
+		total += size
+	}
+	assert.Equal(t, stat.ReclaimSize, total)
+	assert.Greater(t, stat.DataFileReclaimSize[0], int64(0))
+
+	// 2.只能 merge 旧的数据文件
+	err = db.MergeFiles([]uint32{db.activeFile.FileId})
+	assert.Equal(t, ErrInvalidMergeFile, err)
+
+	// 3.不 merge 第一个文件，被删除的数据依然不能在重启之后重新生效
+	var fids []uint32
+	for fid := range db.olderFiles {
+		if fid != 0 && db.fileGarbage[fid] > 0 {
+			fids = append(fids, fid)
+		}
+	}
+	assert.NotEmpty(t, fids)
+	err = db.MergeFiles(fids)
+	assert.Nil(t, err)
+	err = db.MergeFiles(fids)
+	assert.Equal(t, ErrMergeNotApplied, err)
+	err = db.Merge()
+	assert.Equal(t, ErrMergeNotApplied, err)
+
+	oldSize, oldGarbage := make(map[uint32]int64), make(map[uint32]int64)
+	for _, fid := range fids {
+		oldSize[fid], _ = db.olderFiles[fid].IoManager.Size()
+		oldGarbage[fid] = db.fileGarbage[fid]
+	}
+	keyNum := db.Stat().KeyNum
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, keyNum, db2.Stat().KeyNum)
+	var oldTotal, newTotal int64
+	for _, fid := range fids {
+		size, _ := db2.olderFiles[fid].IoManager.Size()
+		assert.LessOrEqual(t, size, oldSize[fid])
+		assert.LessOrEqual(t, db2.fileGarbage[fid], oldGarbage[fid]) // 只剩下保留的删除标识
+		oldTotal += oldSize[fid]
+		newTotal += size
+	}
+	assert.Less(t, newTotal, oldTotal)
+	for i := 0; i < 50; i++ {
+		_, err := db2.Get(utils.GetTestKey(i))
+		assert.Equal(t, ErrKeyNotFound, err)
+	}
+	for i := 100; i < 300; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// 4.merge 过的文件的索引从 hint 文件加载，重写之后需要重新生成 hint 文件
+	opts.DataFileMergeRatio = 0
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	err = db3.Merge()
+	assert.Nil(t, err)
+	err = db3.Close()
+	assert.Nil(t, err)
+
+	db4, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(dir + "/" + data.HintFileName)
+	assert.Nil(t, err)
+	for i := 500; i < 1000; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db4.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	fids = db4.PickMergeFiles()
+	assert.NotEmpty(t, fids)
+	err = db4.MergeFiles(fids)
+	assert.Nil(t, err)
+	err = db4.Close()
+	assert.Nil(t, err)
+
+	db5, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db5.Close()
+	}()
+	assert.Equal(t, keyNum, db5.Stat().KeyNum)
+	for i := 100; i < 1000; i++ {
+		val, err := db5.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		if values[i] != nil {
+			assert.Equal(t, values[i], val)
+		}
+	}
+}
+
+func TestDB_MergeFiles_ApplyFailed(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-merge-files-apply")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 50; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.MergeFiles([]uint32{0})
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 数据文件的位置被目录占用，替换失败，merge 目录需要保留
+	dataFileName := data.GetDataFileName(dir, 0)
+	err = os.Remove(dataFileName)
+	assert.Nil(t, err)
+	err = os.MkdirAll(filepath.Join(dataFileName, "block"), os.ModePerm)
+	assert.Nil(t, err)
+	_, err = Open(opts)
+	assert.NotNil(t, err)
+	_, err = os.Stat(filepath.Join(db.getMergePath(), data.MergeFinishedFileName))
+	assert.Nil(t, err)
+
+	// 下次打开时重新替换
+	err = os.RemoveAll(dataFileName)
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, 950, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(60))
+	assert.Nil(t, err)
+}
@@ -0,0 +1,239 @@
+package fdb
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/calmw/fdb/data"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 延迟直方图的桶上限（秒）
+var latencyBuckets = []float64{
+	0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10,
+}
+
+// merge 耗时直方图的桶上限（秒）
+var mergeDurationBuckets = []float64{
+	0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800, 3600,
+}
+
+// Metrics 存储引擎运行指标的快照，计数器从数据库打开时开始累计
+type Metrics struct {
+	PutCount    uint64 // Put、PutWithTTL 的调用次数
+	GetCount    uint64 // Get 的调用次数
+	DeleteCount uint64 // Delete 的调用次数
+
+	PutLatency    Histogram // Put、PutWithTTL 的耗时（秒）
+	GetLatency    Histogram // Get 的耗时（秒）
+	DeleteLatency Histogram // Delete 的耗时（秒）
+
+	BytesWritten uint64 // 写入数据文件和 blob 文件的字节数
+	BytesRead    uint64 // 读取 value 时从数据文件和 blob 文件中读取的字节数
+	SyncCount    uint64 // 持久化活跃文件的次数
+
+	MergeCount          uint64    // 完成的 merge（包括 MergeFiles）的次数
+	MergeDuration       Histogram // merge 的耗时（秒）
+	MergeReclaimedBytes uint64    // merge 之后数据文件减少的字节数，在下次打开数据库时生效
+
+	IndexSize    uint   // 内存索引中key的数量
+	ActiveFileId uint32 // 当前活跃文件的ID
+}
+
+// Histogram 直方图快照，Counts 中每个桶的计数和 Prometheus 一样是累计的
+type Histogram struct {
+	Buckets []float64 // 每个桶的上限
+	Counts  []uint64  // 小于等于每个桶上限的观测数量
+	Count   uint64    // 观测的总数量
+	Sum     float64   // 观测值的总和
+}
+
+// 可以并发更新的直方图
+type histogram struct {
+	buckets []float64
+	counts  []uint64 // 落在每个桶中的数量，不是累计的
+	count   uint64
+	sum     uint64 // 观测值总和，单位纳秒
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	for i, bucket := range h.buckets {
+		if seconds <= bucket {
+			atomic.AddUint64(&h.counts[i], 1)
+			break
+		}
+	}
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddUint64(&h.sum, uint64(d))
+}
+
+func (h *histogram) snapshot() Histogram {
+	snap := Histogram{
+		Buckets: h.buckets,
+		Counts:  make([]uint64, len(h.buckets)),
+		Count:   atomic.LoadUint64(&h.count),
+		Sum:     time.Duration(atomic.LoadUint64(&h.sum)).Seconds(),
+	}
+	var cumulative uint64
+	for i := range h.buckets {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		snap.Counts[i] = cumulative
+	}
+	return snap
+}
+
+// 存储引擎运行指标，所有的字段都通过原子操作更新，不需要持有互斥锁
+// 原子操作的 uint64 字段放在最前面，保证在32位平台上8字节对齐
+type metrics struct {
+	putCount            uint64
+	getCount            uint64
+	deleteCount         uint64
+	bytesWritten        uint64
+	bytesRead           uint64
+	syncCount           uint64
+	mergeCount          uint64
+	mergeReclaimedBytes uint64
+
+	putLatency    *histogram
+	getLatency    *histogram
+	deleteLatency *histogram
+	mergeDuration *histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		putLatency:    newHistogram(latencyBuckets),
+		getLatency:    newHistogram(latencyBuckets),
+		deleteLatency: newHistogram(latencyBuckets),
+		mergeDuration: newHistogram(mergeDurationBuckets),
+	}
+}
+
+func (m *metrics) observePut(start time.Time) {
+	atomic.AddUint64(&m.putCount, 1)
+	m.putLatency.observe(time.Since(start))
+}
+
+func (m *metrics) observeGet(start time.Time) {
+	atomic.AddUint64(&m.getCount, 1)
+	m.getLatency.observe(time.Since(start))
+}
+
+func (m *metrics) observeDelete(start time.Time) {
+	atomic.AddUint64(&m.deleteCount, 1)
+	m.deleteLatency.observe(time.Since(start))
+}
+
+func (m *metrics) observeMerge(start time.Time, reclaimed int64) {
+	atomic.AddUint64(&m.mergeCount, 1)
+	m.mergeDuration.observe(time.Since(start))
+	if reclaimed > 0 {
+		atomic.AddUint64(&m.mergeReclaimedBytes, uint64(reclaimed))
+	}
+}
+
+// Metrics 返回存储引擎运行指标的快照，不会遍历数据目录
+func (db *DB) Metrics() *Metrics {
+	m := db.metrics
+	snap := &Metrics{
+		PutCount:            atomic.LoadUint64(&m.putCount),
+		GetCount:            atomic.LoadUint64(&m.getCount),
+		DeleteCount:         atomic.LoadUint64(&m.deleteCount),
+		PutLatency:          m.putLatency.snapshot(),
+		GetLatency:          m.getLatency.snapshot(),
+		DeleteLatency:       m.deleteLatency.snapshot(),
+		BytesWritten:        atomic.LoadUint64(&m.bytesWritten),
+		BytesRead:           atomic.LoadUint64(&m.bytesRead),
+		SyncCount:           atomic.LoadUint64(&m.syncCount),
+		MergeCount:          atomic.LoadUint64(&m.mergeCount),
+		MergeDuration:       m.mergeDuration.snapshot(),
+		MergeReclaimedBytes: atomic.LoadUint64(&m.mergeReclaimedBytes),
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	snap.IndexSize = uint(db.index.Size())
+	if db.activeFile != nil {
+		snap.ActiveFileId = db.activeFile.FileId
+	}
+	return snap
+}
+
+// MetricsHandler 以 Prometheus 文本格式输出存储引擎运行指标的 HTTP 处理器
+func (db *DB) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = db.Metrics().WritePrometheus(writer)
+	})
+}
+
+// WritePrometheus 以 Prometheus 文本格式输出指标
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	writeCounter(bw, "fdb_put_total", "Total number of put operations.", m.PutCount)
+	writeCounter(bw, "fdb_get_total", "Total number of get operations.", m.GetCount)
+	writeCounter(bw, "fdb_delete_total", "Total number of delete operations.", m.DeleteCount)
+	writeHistogram(bw, "fdb_put_duration_seconds", "Latency of put operations.", m.PutLatency)
+	writeHistogram(bw, "fdb_get_duration_seconds", "Latency of get operations.", m.GetLatency)
+	writeHistogram(bw, "fdb_delete_duration_seconds", "Latency of delete operations.", m.DeleteLatency)
+	writeCounter(bw, "fdb_written_bytes_total", "Total bytes written to data and blob files.", m.BytesWritten)
+	writeCounter(bw, "fdb_read_bytes_total", "Total bytes read from data and blob files.", m.BytesRead)
+	writeCounter(bw, "fdb_sync_total", "Total number of active file syncs.", m.SyncCount)
+	writeCounter(bw, "fdb_merge_total", "Total number of completed merges.", m.MergeCount)
+	writeHistogram(bw, "fdb_merge_duration_seconds", "Duration of completed merges.", m.MergeDuration)
+	writeCounter(bw, "fdb_merge_reclaimed_bytes_total", "Total bytes reclaimed by merges.", m.MergeReclaimedBytes)
+	writeGauge(bw, "fdb_index_keys", "Number of keys in the in-memory index.", uint64(m.IndexSize))
+	writeGauge(bw, "fdb_active_file_id", "Id of the active data file.", uint64(m.ActiveFileId))
+	return bw.Flush()
+}
+
+func writeCounter(w *bufio.Writer, name, help string, value uint64) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", name, help, name, name, value)
+}
+
+func writeGauge(w *bufio.Writer, name, help string, value uint64) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %d\n", name, help, name, name, value)
+}
+
+func writeHistogram(w *bufio.Writer, name, help string, h Histogram) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for i, bucket := range h.Buckets {
+		_, _ = fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, strconv.FormatFloat(bucket, 'g', -1, 64), h.Counts[i])
+	}
+	_, _ = fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.Count)
+	_, _ = fmt.Fprintf(w, "%s_sum %s\n", name, strconv.FormatFloat(h.Sum, 'g', -1, 64))
+	_, _ = fmt.Fprintf(w, "%s_count %d\n", name, h.Count)
+}
+
+// 目录中所有数据文件的大小，用于计算 merge 回收的数据量
+func dataFilesSize(dirPath string) (int64, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return 0, err
+		}
+		size += info.Size()
+	}
+	return size, nil
+}
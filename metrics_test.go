@@ -0,0 +1,71 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDB_Metrics(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-metrics")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Sync()
+	assert.Nil(t, err)
+
+	metrics := db.Metrics()
+	assert.Equal(t, uint64(1000), metrics.PutCount)
+	assert.Equal(t, uint64(100), metrics.GetCount)
+	assert.Equal(t, uint64(500), metrics.DeleteCount)
+	assert.Equal(t, uint64(1000), metrics.PutLatency.Count)
+	assert.Equal(t, metrics.PutLatency.Count, metrics.PutLatency.Counts[len(metrics.PutLatency.Counts)-1])
+	assert.Greater(t, metrics.BytesWritten, uint64(1000*128))
+	assert.Greater(t, metrics.BytesRead, uint64(100*128))
+	assert.Greater(t, metrics.SyncCount, uint64(0))
+	assert.Equal(t, uint(500), metrics.IndexSize)
+	assert.Greater(t, metrics.ActiveFileId, uint32(0))
+	assert.Equal(t, uint64(0), metrics.MergeCount)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+	metrics = db.Metrics()
+	assert.Equal(t, uint64(1), metrics.MergeCount)
+	assert.Equal(t, uint64(1), metrics.MergeDuration.Count)
+	assert.Greater(t, metrics.MergeReclaimedBytes, uint64(0))
+
+	// Prometheus 文本格式
+	recorder := httptest.NewRecorder()
+	db.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/fdb/metrics", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain"))
+	body := recorder.Body.String()
+	assert.Contains(t, body, "# TYPE fdb_put_total counter\nfdb_put_total 1000\n")
+	assert.Contains(t, body, "fdb_get_duration_seconds_bucket{le=\"+Inf\"} 100\n")
+	assert.Contains(t, body, "fdb_delete_duration_seconds_count 500\n")
+	assert.Contains(t, body, "fdb_merge_total 1\n")
+	assert.Contains(t, body, "fdb_index_keys 500\n")
+	assert.Contains(t, body, "fdb_active_file_id ")
+}
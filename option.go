@@ -1,13 +1,60 @@
 package fdb
 
+import "time"
+
 type Options struct {
 	DirPath            string    // 数据库数据目录
 	DataFileSize       int64     // 数据文件的大小
-	SyncWrite          bool      // 每次写入是否持久化
+	SyncWrite          bool      // 每次写入是否持久化，并发的写入会组提交，共用一次持久化
 	IndexType          IndexType // 索引类型
 	BytesPerWrite      uint      // 累计多少字节时执行持久化
-	MMapAtStartup      bool      // 在启动的时候是否使用MMap加载数据
+	MMapAtStartup      bool      // 在启动的时候是否使用MMap加载数据，只读模式下不使用
+	StrictRecovery     bool      // 最新的数据文件末尾有损坏的记录时启动失败，默认截断损坏的数据之后继续启动
 	DataFileMergeRatio float32   // 数据文件merge的阀值,无效数据占总数据的比例
+
+	// 只读模式，不获取文件锁，可以和正在写入的进程以及其他只读的进程同时打开同一个数据目录
+	// 只加载打开时磁盘上已有的数据，不会创建或修改任何文件，所有的写入操作以及 merge 都返回 ErrReadOnly，不支持B+树索引
+	ReadOnly bool
+	Follow   FollowOptions // 只读模式下在后台跟随写入的进程加载新写入的数据，默认不开启
+
+	// 后台每隔多久持久化一次还没有持久化的写入，0表示不开启，可以限制没有开启 SyncWrite 时宕机丢失数据的时间范围
+	SyncInterval time.Duration
+
+	// 单个数据文件中无效数据占该文件的比例达到该值时，才会被 PickMergeFiles 选中
+	DataFileGarbageRatio float32
+
+	Compression          CompressionType // value 的压缩算法，默认不压缩
+	CompressionThreshold int             // value 的大小达到该值（字节）时才进行压缩
+
+	// 数据加密密钥，长度为 16、24 或 32 字节，为空表示不加密
+	// 数据文件、hint 文件、seq-no 文件、merge-finished 文件使用 AES-GCM 加密
+	// B+树索引会把 key 明文写入索引文件，所以不能和 IndexTypeBPlusTree 同时使用
+	EncryptionKey []byte
+
+	// value 的大小超过该值（字节）时分离存储到单独的 blob 文件中，数据文件中只保存 blob 位置，0表示不分离
+	// merge 只会重写数据文件中的 blob 位置，blob 文件通过 CompactBlobs 单独回收
+	ValueThreshold   int
+	BlobGarbageRatio float32 // blob 文件中无效数据达到该比例时，才会被 CompactBlobs 重写
+
+	AutoMerge AutoMergeOptions // 后台自动 merge，默认不开启
+	Listener  Listener         // 存储引擎事件的监听器，默认为空
+}
+
+// FollowOptions 只读模式下跟随写入进程的配置项
+type FollowOptions struct {
+	Interval time.Duration // 每隔多久加载一次写入的进程新写入的数据，0表示不开启，也可以调用 Refresh 手动加载
+	OnError  func(error)   // 加载失败时的回调，下次仍然会继续尝试
+}
+
+// AutoMergeOptions 后台自动 merge 配置项
+type AutoMergeOptions struct {
+	Enable         bool          // 是否在后台自动 merge
+	CheckInterval  time.Duration // 检查是否需要 merge 的间隔
+	WindowStart    time.Duration // 允许 merge 的时间窗口起点，相对于当天零点（本地时间）
+	WindowEnd      time.Duration // 允许 merge 的时间窗口终点，小于起点表示跨越零点，和起点相等表示不限制时间
+	MinReclaimSize int64         // 可以回收的数据量（字节）至少达到该值才会 merge，同时还需要满足 DataFileMergeRatio
+	OnError        func(error)   // merge 失败时的回调，未达到阀值不算失败
+	Selective      bool          // 只 merge 无效数据占比达到 DataFileGarbageRatio 的数据文件，而不是全部的旧数据文件
 }
 
 // IteratorOptions 索引迭代器配置项
@@ -22,6 +69,21 @@ type WriteBatchOptions struct {
 	SyncWrites  bool // 提交时是否Sync持久化
 }
 
+// WatchOptions 订阅key变更的配置项
+type WatchOptions struct {
+	BufferSize int // 事件缓冲区大小，缓冲区满时新的事件会被丢弃
+}
+
+// BackupOptions 流式备份配置项
+type BackupOptions struct {
+	Gzip bool // 是否使用 gzip 压缩 tar 包
+}
+
+// VerifyOptions 离线检查数据目录的配置项
+type VerifyOptions struct {
+	EncryptionKey []byte // 数据库的加密密钥，未加密时为空
+}
+
 type IndexType = int8
 
 const (
@@ -30,13 +92,45 @@ const (
 	IndexTypeBPlusTree                      // B+树索引，将索引存储到磁盘上
 )
 
+type CompressionType = int8
+
+const (
+	CompressionNone  CompressionType = iota // 不压缩
+	CompressionFlate                        // 使用标准库 compress/flate 压缩
+)
+
 var DefaultOption = Options{
 	DirPath:            "./fdb",
 	DataFileSize:       256 * 1024 * 1024,
 	SyncWrite:          false,
 	IndexType:          IndexTypeBtree,
 	MMapAtStartup:      true,
+	StrictRecovery:     false,
 	DataFileMergeRatio: 0.2,
+
+	ReadOnly: false,
+	Follow: FollowOptions{
+		Interval: 0,
+	},
+
+	SyncInterval: 0,
+
+	DataFileGarbageRatio: 0.5,
+
+	Compression:          CompressionNone,
+	CompressionThreshold: 1024,
+
+	ValueThreshold:   0,
+	BlobGarbageRatio: 0.5,
+
+	AutoMerge: AutoMergeOptions{
+		Enable:         false,
+		CheckInterval:  10 * time.Minute,
+		WindowStart:    0,
+		WindowEnd:      0,
+		MinReclaimSize: 0,
+	},
+	Listener: nil,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
@@ -48,3 +142,15 @@ var DefaultWriteBatchOptions = WriteBatchOptions{
 	MaxBatchNum: 10000,
 	SyncWrites:  true,
 }
+
+var DefaultWatchOptions = WatchOptions{
+	BufferSize: 1024,
+}
+
+var DefaultBackupOptions = BackupOptions{
+	Gzip: true,
+}
+
+var DefaultVerifyOptions = VerifyOptions{
+	EncryptionKey: nil,
+}
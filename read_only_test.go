@@ -0,0 +1,158 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_ReadOnly(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-read-only")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 500; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	err = db.Delete(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+
+	// 1.写入的进程没有关闭时，多个只读的进程可以同时打开
+	roOpts := opts
+	roOpts.ReadOnly = true
+	ro1, err := Open(roOpts)
+	assert.Nil(t, err)
+	ro2, err := Open(roOpts)
+	assert.Nil(t, err)
+	for _, ro := range []*DB{ro1, ro2} {
+		assert.Equal(t, uint(499), ro.Stat().KeyNum)
+		_, err = ro.Get(utils.GetTestKey(0))
+		assert.Equal(t, ErrKeyNotFound, err)
+		val, err := ro.Get(utils.GetTestKey(100))
+		assert.Nil(t, err)
+		assert.Equal(t, values[100], val)
+	}
+
+	// 2.所有的写入操作都被拒绝
+	assert.Equal(t, ErrReadOnly, ro1.Put(utils.GetTestKey(1), []byte("value")))
+	assert.Equal(t, ErrReadOnly, ro1.Delete(utils.GetTestKey(1)))
+	assert.Equal(t, ErrReadOnly, ro1.DeletePrefix([]byte("fdb")))
+	_, err = ro1.Increment([]byte("counter"), 1)
+	assert.Equal(t, ErrReadOnly, err)
+	assert.Equal(t, ErrReadOnly, ro1.Merge())
+	assert.Equal(t, ErrReadOnly, ro1.MergeFiles([]uint32{0}))
+	assert.Equal(t, ErrReadOnly, ro1.CheckpointIndex())
+	wb := ro1.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Equal(t, ErrReadOnly, wb.Put(utils.GetTestKey(1), []byte("value")))
+	txn := ro1.NewTxn(DefaultWriteBatchOptions)
+	assert.Equal(t, ErrReadOnly, txn.Delete(utils.GetTestKey(1)))
+
+	// 3.只读的进程打开之后写入的数据不可见
+	err = db.Put(utils.GetTestKey(1000), []byte("value"))
+	assert.Nil(t, err)
+	_, err = ro1.Get(utils.GetTestKey(1000))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = ro1.Close()
+	assert.Nil(t, err)
+	err = ro2.Close()
+	assert.Nil(t, err)
+	// 只读模式不会创建或者删除任何文件
+	entries2, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), len(entries2))
+
+	// 4.数据目录不存在时不会创建
+	roOpts.DirPath = filepath.Join(dir, "not-exist")
+	_, err = Open(roOpts)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(roOpts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+
+	roOpts.DirPath = dir
+	roOpts.IndexType = IndexTypeBPlusTree
+	_, err = Open(roOpts)
+	assert.NotNil(t, err)
+}
+
+func TestDB_ReadOnly_OpenFilesReadOnly(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-read-only-files")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.ValueThreshold = 256
+	opts.EncryptionKey = []byte("0123456789abcdef")
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 500; i < 520; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(512))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	// merge 的结果生效，数据目录中有 hint 文件
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	modTimes := make(map[string]int64)
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		assert.Nil(t, err)
+		modTimes[entry.Name()] = info.ModTime().UnixNano()
+	}
+
+	// 所有的文件都以只读方式打开，无法写入
+	roOpts := opts
+	roOpts.ReadOnly = true
+	roOpts.MMapAtStartup = true
+	ro, err := Open(roOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(520), ro.Stat().KeyNum)
+	_, err = ro.activeFile.IoManager.Write([]byte("x"))
+	assert.NotNil(t, err)
+	for _, dataFile := range ro.olderFiles {
+		_, err = dataFile.IoManager.Write([]byte("x"))
+		assert.NotNil(t, err)
+	}
+	assert.NotNil(t, ro.activeBlobFile)
+	_, err = ro.activeBlobFile.IoManager.Write([]byte("x"))
+	assert.NotNil(t, err)
+	err = ro.Close()
+	assert.Nil(t, err)
+
+	// 数据目录没有任何变化
+	entries2, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), len(entries2))
+	for _, entry := range entries2 {
+		info, err := entry.Info()
+		assert.Nil(t, err)
+		assert.Equal(t, modTimes[entry.Name()], info.ModTime().UnixNano(), entry.Name())
+	}
+}
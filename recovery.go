@@ -0,0 +1,47 @@
+package fdb
+
+import (
+	"fmt"
+	"github.com/calmw/fdb/data"
+	"os"
+)
+
+// TruncatedTail 启动时从最新的数据文件末尾截断的损坏数据，通常是崩溃或断电时没有写完整的记录
+type TruncatedTail struct {
+	FileId uint32 // 数据文件ID
+	Offset int64  // 截断的位置，即最后一条完整记录的末尾
+	Size   int64  // 被丢弃的数据大小
+	Err    error  // 读取损坏数据时的错误，数据不完整时为空
+}
+
+// TruncatedTail 返回启动时被截断的损坏数据，没有截断时返回空
+func (db *DB) TruncatedTail() *TruncatedTail {
+	return db.truncatedTail
+}
+
+// 将最新的数据文件截断到最后一条完整记录的末尾，之后追加写入的数据才能和索引中的位置对应
+// 损坏位置之后还能读取到完整的记录时，说明损坏的不是末尾没有写完的记录，不截断并返回 ErrDataFileCorrupted，由 Repair 恢复
+func (db *DB) truncateTornTail(dataFile *data.DataFile, offset int64, readErr error) error {
+	size, err := dataFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if size <= offset {
+		return nil
+	}
+	for next := offset + 1; next < size; next++ {
+		if _, ok := readValidRecord(dataFile, next); ok {
+			return fmt.Errorf("%w: data file %d at offset %d", ErrDataFileCorrupted, dataFile.FileId, offset)
+		}
+	}
+	if err = os.Truncate(data.GetDataFileName(db.options.DirPath, dataFile.FileId), offset); err != nil {
+		return err
+	}
+	db.truncatedTail = &TruncatedTail{
+		FileId: dataFile.FileId,
+		Offset: offset,
+		Size:   size - offset,
+		Err:    readErr,
+	}
+	return nil
+}
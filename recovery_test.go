@@ -0,0 +1,132 @@
+package fdb
+
+import (
+	"errors"
+	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_TruncateTornTail(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-torn-tail")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	fileName := data.GetDataFileName(dir, db.activeFile.FileId)
+	size := db.activeFile.WriteOff
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 1.末尾追加一条 CRC 校验失败的记录，严格模式下启动失败
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq(utils.GetTestKey(100), nonTransactionSeqNo),
+		Value: utils.RandomValue(128),
+	})
+	encRecord[len(encRecord)-1] ^= 0xff
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = file.Write(encRecord)
+	assert.Nil(t, err)
+	_ = file.Close()
+
+	strictOpts := opts
+	strictOpts.StrictRecovery = true
+	_, err = Open(strictOpts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+
+	// 2.默认截断损坏的记录之后继续启动
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, &TruncatedTail{
+		FileId: db2.activeFile.FileId,
+		Offset: size,
+		Size:   int64(len(encRecord)),
+		Err:    data.ErrInvalidCRC,
+	}, db2.TruncatedTail())
+	assert.Equal(t, 100, len(db2.ListKeys()))
+	err = db2.Put(utils.GetTestKey(100), []byte("value"))
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// 3.最后一条记录只写了一部分
+	stat, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	err = os.Truncate(fileName, stat.Size()-3)
+	assert.Nil(t, err)
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db3.Close()
+	}()
+	assert.NotNil(t, db3.TruncatedTail())
+	assert.Equal(t, size, db3.TruncatedTail().Offset)
+	assert.Equal(t, 100, len(db3.ListKeys()))
+	err = db3.Put(utils.GetTestKey(100), []byte("value"))
+	assert.Nil(t, err)
+	val, err := db3.Get(utils.GetTestKey(100))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+}
+
+func TestDB_CorruptedBeforeTail(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-corrupted-before-tail")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	fileName := data.GetDataFileName(dir, db.activeFile.FileId)
+	err = db.Close()
+	assert.Nil(t, err)
+	// 删除索引检查点，打开时需要从数据文件中加载索引
+	err = os.Remove(filepath.Join(dir, data.IndexCheckpointFileName))
+	assert.Nil(t, err)
+
+	// 最新的数据文件中间的一个字节损坏，之后的记录仍然是完整的
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	content[100] ^= 0xff
+	err = os.WriteFile(fileName, content, 0644)
+	assert.Nil(t, err)
+
+	// 不会截断，启动失败
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, ErrDataFileCorrupted))
+	stat, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(content)), stat.Size())
+
+	// 可以通过 Repair 恢复损坏位置之后的记录
+	report, err := Repair(dir, opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = os.RemoveAll(report.BackupDir)
+	}()
+	assert.Greater(t, report.KeyNum, uint(990))
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db2.Close()
+	}()
+	val, err := db2.Get(utils.GetTestKey(999))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+}
@@ -33,6 +33,7 @@ var supportCommands = map[string]cmdHandler{
 	"rpop":      rPop,
 	"zadd":      zAdd,
 	"zscore":    zScore,
+	"info":      info,
 }
 
 type FdbClient struct {
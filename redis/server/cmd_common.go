@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bytes"
 	"errors"
 	"github.com/calmw/fdb"
 	"github.com/calmw/fdb/redis"
@@ -63,3 +64,17 @@ func Type(cli *FdbClient, args [][]byte) (interface{}, error) {
 
 	return redcon.SimpleString(dataTypeMap[dataType]), nil
 }
+
+// 以 Prometheus 文本格式返回存储引擎的运行指标
+func info(cli *FdbClient, args [][]byte) (interface{}, error) {
+	if len(args) > 1 {
+		return nil, newWrongNumberOfArgsError("info")
+	}
+
+	var buf bytes.Buffer
+	if err := cli.DB.Metrics().WritePrometheus(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
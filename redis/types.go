@@ -33,3 +33,8 @@ func NewRedisDataStructure(options fdb.Options) (*RedisDataStructure, error) {
 func (rds *RedisDataStructure) Close() error {
 	return rds.db.Close()
 }
+
+// Metrics 返回底层存储引擎运行指标的快照
+func (rds *RedisDataStructure) Metrics() *fdb.Metrics {
+	return rds.db.Metrics()
+}
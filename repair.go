@@ -0,0 +1,247 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/fio"
+	"github.com/gofrs/flock"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// RepairReport 修复数据目录的结果
+type RepairReport struct {
+	Dir              string              `json:"dir"`
+	Files            []*RepairFileReport `json:"files"`                       // 每个数据文件的修复结果
+	RecoveredRecords int                 `json:"recovered_records"`           // 恢复的记录数量
+	LostBytes        int64               `json:"lost_bytes"`                  // 丢弃的数据大小
+	KeyNum           uint                `json:"key_num"`                     // 修复之后 key 的数量
+	BackupDir        string              `json:"backup_dir,omitempty"`        // 被重写的原始数据文件的备份目录
+	RemovedMergeDir  string              `json:"removed_merge_dir,omitempty"` // 被删除的 merge 目录
+}
+
+// RepairFileReport 单个数据文件的修复结果
+type RepairFileReport struct {
+	Name       string       `json:"name"`
+	Records    int          `json:"records"`     // 恢复的记录数量
+	LostRanges []*LostRange `json:"lost_ranges"` // 丢弃的数据段
+}
+
+// LostRange 数据文件中因为损坏而被丢弃的数据段
+type LostRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// 数据文件中连续的完好记录
+type salvageSegment struct {
+	offset int64
+	length int64
+}
+
+// Repair 离线修复数据目录，需要在数据库关闭的时候执行
+// 遇到损坏的记录时向后逐字节查找下一条 CRC 校验通过的记录，有损坏的数据文件只保留完好的记录重写，原始文件移动到备份目录中
+// 之后重新加载所有的数据文件并执行 merge，重新生成 hint 文件
+// opts 提供加密密钥、数据文件大小等配置，DirPath 会被替换为 dir，B+树索引不会被重建，所以不支持
+func Repair(dir string, opts Options) (*RepairReport, error) {
+	if opts.IndexType == IndexTypeBPlusTree {
+		return nil, ErrRepairUnsupportedIndex
+	}
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.StrictRecovery = false
+	opts.AutoMerge.Enable = false
+	if err := checkOptions(opts); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	report, err := salvageDataFiles(dir, opts.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// 重新加载所有的数据文件，merge 之后只保留有效的数据，并生成新的 hint 文件
+	db, err := Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Merge(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if err = db.Close(); err != nil {
+		return nil, err
+	}
+	// 再次打开使 merge 生效
+	if db, err = Open(opts); err != nil {
+		return nil, err
+	}
+	report.KeyNum = db.Stat().KeyNum
+	if err = db.Close(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// 重写有损坏的数据文件，并删除依赖于数据文件中位置的 hint 文件、merge 完成标识及索引检查点
+func salvageDataFiles(dir string, encryptionKey []byte) (*RepairReport, error) {
+	fileLock := flock.New(filepath.Join(dir, dbFileLock))
+	hold, err := fileLock.TryLock()
+	if err != nil {
+		return nil, err
+	}
+	if !hold {
+		return nil, ErrDatabaseIsUsing
+	}
+	defer func() {
+		_ = fileLock.Unlock()
+	}()
+
+	cipher, err := verifyCipher(dir, encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	dataFiles, err := openVerifyDataFiles(dir, cipher, fio.MemoryMap)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, dataFile := range dataFiles {
+			_ = dataFile.Close()
+		}
+	}()
+	fileIds := make([]uint32, 0, len(dataFiles))
+	for fid := range dataFiles {
+		fileIds = append(fileIds, fid)
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+
+	report := &RepairReport{Dir: dir}
+	for _, fid := range fileIds {
+		dataFile := dataFiles[fid]
+		fileReport, segments, err := salvageDataFile(dataFile)
+		if err != nil {
+			return nil, err
+		}
+		report.Files = append(report.Files, fileReport)
+		report.RecoveredRecords += fileReport.Records
+		if len(fileReport.LostRanges) == 0 {
+			continue
+		}
+		for _, lost := range fileReport.LostRanges {
+			report.LostBytes += lost.Length
+		}
+
+		if report.BackupDir == "" {
+			if report.BackupDir, err = os.MkdirTemp(path.Dir(path.Clean(dir)), path.Base(dir)+"-repair-"); err != nil {
+				return nil, err
+			}
+		}
+		// 重写之后记录的偏移量发生了变化，之前保存的这个文件中的日志位置不再有效
+		if err = addRewrittenFiles(dir, cipher, []uint32{fid}); err != nil {
+			return nil, err
+		}
+		if err = rewriteSalvagedFile(dir, report.BackupDir, dataFile, segments); err != nil {
+			return nil, err
+		}
+	}
+
+	// merge 目录中的数据来自于旧的数据文件，删除之后不会丢失数据
+	mergePath := path.Join(path.Dir(path.Clean(dir)), path.Base(dir)+mergeDirName)
+	if _, err := os.Stat(mergePath); err == nil {
+		if err = os.RemoveAll(mergePath); err != nil {
+			return nil, err
+		}
+		report.RemovedMergeDir = mergePath
+	}
+	// merge 过的数据文件中只有有效的数据，没有 hint 文件时直接从数据文件中加载也是正确的
+	for _, fileName := range []string{data.HintFileName, data.MergeFinishedFileName, data.IndexCheckpointFileName} {
+		if err := os.Remove(filepath.Join(dir, fileName)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// 找出数据文件中所有完好的记录，遇到损坏的记录时向后逐字节查找下一条完好的记录
+func salvageDataFile(dataFile *data.DataFile) (*RepairFileReport, []*salvageSegment, error) {
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return nil, nil, err
+	}
+	report := &RepairFileReport{
+		Name:       filepath.Base(data.GetDataFileName("", dataFile.FileId)),
+		LostRanges: []*LostRange{},
+	}
+	var segments []*salvageSegment
+	var offset int64
+	for offset < fileSize {
+		if size, ok := readValidRecord(dataFile, offset); ok {
+			report.Records++
+			if n := len(segments); n > 0 && segments[n-1].offset+segments[n-1].length == offset {
+				segments[n-1].length += size
+			} else {
+				segments = append(segments, &salvageSegment{offset: offset, length: size})
+			}
+			offset += size
+			continue
+		}
+
+		next := offset + 1
+		for ; next < fileSize; next++ {
+			if _, ok := readValidRecord(dataFile, next); ok {
+				break
+			}
+		}
+		report.LostRanges = append(report.LostRanges, &LostRange{Offset: offset, Length: next - offset})
+		offset = next
+	}
+	return report, segments, nil
+}
+
+func readValidRecord(dataFile *data.DataFile, offset int64) (int64, bool) {
+	logRecord, size, err := dataFile.ReadLogRecord(offset)
+	if err != nil || logRecord.Type > data.LogRecordBlobRef {
+		return 0, false
+	}
+	return size, true
+}
+
+// 将完好的记录按原来的顺序写到新的文件中，替换掉原始文件，原始文件移动到备份目录
+func rewriteSalvagedFile(dir, backupDir string, dataFile *data.DataFile, segments []*salvageSegment) error {
+	output, err := data.OpenDataFile(backupDir, dataFile.FileId, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		buf := make([]byte, segment.length)
+		if _, err = dataFile.IoManager.Read(buf, segment.offset); err != nil {
+			_ = output.Close()
+			return err
+		}
+		if err = output.Write(buf); err != nil {
+			_ = output.Close()
+			return err
+		}
+	}
+	if err = output.Sync(); err != nil {
+		_ = output.Close()
+		return err
+	}
+	if err = output.Close(); err != nil {
+		return err
+	}
+
+	fileName := data.GetDataFileName(dir, dataFile.FileId)
+	salvagedFileName := data.GetDataFileName(backupDir, dataFile.FileId)
+	if err = os.Rename(fileName, salvagedFileName+".corrupted"); err != nil {
+		return err
+	}
+	return os.Rename(salvagedFileName, fileName)
+}
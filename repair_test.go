@@ -0,0 +1,100 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-repair")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 1000; i++ {
+		values[i] = utils.RandomValue(128)
+		err := db.Put(utils.GetTestKey(i), values[i])
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 数据库打开时不能修复
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = Repair(dir, opts)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// 1.损坏第一个数据文件中间的一段数据
+	fileName := data.GetDataFileName(dir, 0)
+	file, err := os.OpenFile(fileName, os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte("corrupted"), 10*1024)
+	assert.Nil(t, err)
+	err = file.Close()
+	assert.Nil(t, err)
+
+	// 不使用索引检查点，重放所有的数据文件
+	err = os.Remove(dir + "/" + data.IndexCheckpointFileName)
+	assert.Nil(t, err)
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+	report, err := Verify(dir, DefaultVerifyOptions)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+
+	// 2.修复之后只丢失了损坏的记录
+	repairReport, err := Repair(dir, opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, repairReport)
+	defer func() {
+		_ = os.RemoveAll(repairReport.BackupDir)
+	}()
+	assert.Equal(t, "000000000.data", repairReport.Files[0].Name)
+	assert.Len(t, repairReport.Files[0].LostRanges, 1)
+	lost := repairReport.Files[0].LostRanges[0]
+	assert.LessOrEqual(t, lost.Offset, int64(10*1024))
+	assert.Greater(t, lost.Offset+lost.Length, int64(10*1024))
+	assert.Equal(t, lost.Length, repairReport.LostBytes)
+	for _, fileReport := range repairReport.Files[1:] {
+		assert.Empty(t, fileReport.LostRanges)
+	}
+	assert.Equal(t, 999, repairReport.RecoveredRecords)
+	assert.Equal(t, uint(999), repairReport.KeyNum)
+	_, err = os.Stat(repairReport.BackupDir + "/000000000.data.corrupted")
+	assert.Nil(t, err)
+	_, err = os.Stat(dir + "/" + data.HintFileName)
+	assert.Nil(t, err)
+
+	report, err = Verify(dir, DefaultVerifyOptions)
+	assert.Nil(t, err)
+	assert.True(t, report.OK(), report.Problems)
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	defer func() {
+		_ = db3.Close()
+	}()
+	assert.Equal(t, uint(999), db3.Stat().KeyNum)
+	var missing int
+	for i := 0; i < 1000; i++ {
+		val, err := db3.Get(utils.GetTestKey(i))
+		if err == ErrKeyNotFound {
+			missing++
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, values[i], val)
+	}
+	assert.Equal(t, 1, missing)
+}
@@ -0,0 +1,156 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/index"
+	"sync"
+	"time"
+)
+
+// Snapshot 数据库快照，固定在创建时刻的日志位置上，提供只读的一致性视图
+// 快照创建之后的写入对快照不可见
+// merge 不会修改旧的数据文件，只是在 merge 目录中生成新的文件，并在下一次启动时替换，
+// 快照持有创建时刻所有数据文件的句柄，所以 merge 之后依然可以正常读取
+// 快照同时固定住创建时刻的 blob 文件，被 CompactBlobs 压缩的 blob 文件在快照释放之后才会被删除
+type Snapshot struct {
+	mu        *sync.RWMutex
+	db        *DB
+	seqNo     uint64                    // 快照创建时的事务序列号
+	index     *index.Btree              // 快照创建时刻的内存索引副本
+	files     map[uint32]*data.DataFile // 快照创建时刻的所有数据文件
+	blobFiles map[uint32]*data.DataFile // 快照创建时刻的所有 blob 文件
+	released  bool
+}
+
+// Snapshot 创建一个数据库快照，使用完之后需要调用 Release 释放
+func (db *DB) Snapshot() *Snapshot {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	snap := &Snapshot{
+		mu:        &sync.RWMutex{},
+		db:        db,
+		seqNo:     db.seqNo,
+		files:     make(map[uint32]*data.DataFile, len(db.olderFiles)+1),
+		blobFiles: db.allBlobFiles(),
+	}
+	db.pinBlobFiles()
+	for fid, file := range db.olderFiles {
+		snap.files[fid] = file
+	}
+	if db.activeFile != nil {
+		snap.files[db.activeFile.FileId] = db.activeFile
+	}
+
+	// Btree 索引直接写时复制，其他类型的索引则拷贝一份到 Btree 中
+	if bt, ok := db.index.(*index.Btree); ok {
+		snap.index = bt.Clone()
+	} else {
+		snap.index = index.NewBtree()
+		iterator := db.index.Iterator(false)
+		for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+			snap.index.Put(iterator.Key(), iterator.Value())
+		}
+		iterator.Close()
+	}
+
+	return snap
+}
+
+// SeqNo 快照创建时的事务序列号
+func (s *Snapshot) SeqNo() uint64 {
+	return s.seqNo
+}
+
+// Get 根据key从快照中读取数据
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+
+	logRecordPos := s.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired(time.Now().UnixNano()) {
+		return nil, ErrKeyNotFound
+	}
+	return s.getValue(logRecordPos)
+}
+
+// NewIterator 初始化快照上的迭代器
+func (s *Snapshot) NewIterator(opts IteratorOptions) *Iterator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	idx := s.index
+	if s.released { // 快照已经释放，返回一个空的迭代器
+		idx = index.NewBtree()
+	}
+	return &Iterator{
+		indexIter: idx.Iterator(opts.Reverse),
+		db:        s.db,
+		snapshot:  s,
+		options:   opts,
+	}
+}
+
+// Fold 获取快照中所有的数据，并执行用户指定的操作,函数返回false时终止遍历
+func (s *Snapshot) Fold(fn func(key []byte, value []byte) bool) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return ErrSnapshotReleased
+	}
+
+	iterator := s.index.Iterator(false)
+	defer iterator.Close()
+	now := time.Now().UnixNano()
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
+		val, err := s.getValue(iterator.Value())
+		if err != nil {
+			return err
+		}
+		if !fn(iterator.Key(), val) {
+			break
+		}
+	}
+	return nil
+}
+
+// Release 释放快照
+func (s *Snapshot) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.released {
+		return
+	}
+	s.released = true
+	s.index = nil
+	s.files = nil
+	s.blobFiles = nil
+	s.db.unpinBlobFiles()
+}
+
+// 根据索引信息从快照的数据文件中获取对应的value
+func (s *Snapshot) getValueByPosition(pos *data.LogRecordPos) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+	return s.getValue(pos)
+}
+
+// 根据索引信息从快照的数据文件或 blob 文件中读取value
+// 在访问此方法前必须持有快照的互斥锁
+func (s *Snapshot) getValue(pos *data.LogRecordPos) ([]byte, error) {
+	if pos.Blob != nil {
+		return getValueFromBlobFile(s.blobFiles[pos.Blob.Fid], pos)
+	}
+	return getValueFromDataFile(s.files[pos.Fid], pos)
+}
@@ -0,0 +1,106 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Snapshot(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-snapshot")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	snap := db.Snapshot()
+	defer snap.Release()
+
+	// 快照创建之后的写入对快照不可见
+	err = db.Put(utils.GetTestKey(1), []byte("new value"))
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1000), utils.RandomValue(10))
+	assert.Nil(t, err)
+
+	val1, err := snap.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), val1)
+	val2, err := snap.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(2), val2)
+	_, err = snap.Get(utils.GetTestKey(1000))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	var count int
+	err = snap.Fold(func(key []byte, value []byte) bool {
+		assert.Equal(t, key, value)
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 100, count)
+
+	iterator := snap.NewIterator(DefaultIteratorOptions)
+	count = 0
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		val, err := iterator.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, iterator.Key(), val)
+		count++
+	}
+	iterator.Close()
+	assert.Equal(t, 100, count)
+
+	// 数据库本身读取到的是最新的数据
+	val3, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), val3)
+}
+
+func TestDB_Snapshot_Merge(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-snapshot-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	snap := db.Snapshot()
+	defer snap.Release()
+
+	for i := 0; i < 500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// merge 之后快照依然可以读取到创建时刻的数据
+	for i := 0; i < 1000; i++ {
+		val, err := snap.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+
+	// 释放之后不可再读取
+	snap.Release()
+	_, err = snap.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrSnapshotReleased, err)
+}
@@ -0,0 +1,283 @@
+package fdb
+
+import (
+	"bytes"
+	"github.com/calmw/fdb/data"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Txn 乐观事务，支持读取自己未提交的写入
+// 事务会记录读取过的key在读取时的索引位置，提交时如果这些key的位置发生了变化（被其他写入修改或删除），
+// 说明事务开始后数据被修改过，提交失败并返回 ErrTxnConflict
+// 数据文件是追加写的，每次修改都会产生新的位置，所以比较位置即可判断数据是否被修改
+type Txn struct {
+	options       WriteBatchOptions
+	mu            *sync.Mutex
+	db            *DB
+	readSet       map[string]*data.LogRecordPos // 读取过的key及读取时的索引位置，nil表示读取时key不存在
+	pendingWrites map[string]*data.LogRecord    // 暂存用户写入数据
+	finished      bool                          // 是否已经提交或回滚
+}
+
+// NewTxn 开启一个新的事务
+func (db *DB) NewTxn(opts WriteBatchOptions) *Txn {
+	// B+树索引，不是第一次加载，并且序列号文件不存，禁用事务（原因可能是上一次未正常关闭数据库，导致序列号文件未写成功）
+	if db.options.IndexType == IndexTypeBPlusTree && !db.seqNoFileExists && !db.isInitial {
+		panic("can not use transaction, seqNo file dose not exists")
+	}
+	return &Txn{
+		options:       opts,
+		mu:            &sync.Mutex{},
+		db:            db,
+		readSet:       map[string]*data.LogRecordPos{},
+		pendingWrites: map[string]*data.LogRecord{},
+	}
+}
+
+// Get 读取数据，优先读取事务中未提交的写入
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return nil, ErrTxnFinished
+	}
+
+	// 读取自己的写入
+	if record := txn.pendingWrites[string(key)]; record != nil {
+		if record.Type == data.LogRecordDeleted {
+			return nil, ErrKeyNotFound
+		}
+		return record.Value, nil
+	}
+
+	txn.db.mu.RLock()
+	defer txn.db.mu.RUnlock()
+	logRecordPos := txn.db.index.Get(key)
+	txn.trackRead(key, logRecordPos)
+	if logRecordPos == nil || logRecordPos.IsExpired(time.Now().UnixNano()) {
+		return nil, ErrKeyNotFound
+	}
+	return txn.db.getValueByPosition(logRecordPos)
+}
+
+// Put 写入数据，提交之前对其他读取不可见
+func (txn *Txn) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if txn.db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{
+		Key:   key,
+		Value: value,
+		Type:  data.LogRecordNormal,
+	}
+	return nil
+}
+
+// Delete 删除数据，提交之前对其他读取不可见
+func (txn *Txn) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if txn.db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{
+		Key:  key,
+		Type: data.LogRecordDeleted,
+	}
+	return nil
+}
+
+// Commit 提交事务，检查读取过的key是否被修改，没有冲突则将暂存的数据写到数据文件，并更新内存索引
+func (txn *Txn) Commit() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.finished {
+		return ErrTxnFinished
+	}
+	txn.finished = true
+
+	if len(txn.pendingWrites) > txn.options.MaxBatchNum {
+		return ErrExceedMaxBatchNum
+	}
+
+	// 加锁，保证冲突检查和写入是原子的，需要持久化时和其他写入一起组提交
+	return txn.db.commit(func() error {
+		// 冲突检查
+		for key, readPos := range txn.readSet {
+			if !isSamePos(readPos, txn.db.index.Get([]byte(key))) {
+				return ErrTxnConflict
+			}
+		}
+
+		// 删除不存在的key不需要写入
+		records := make(map[string]*data.LogRecord, len(txn.pendingWrites))
+		for key, record := range txn.pendingWrites {
+			if record.Type == data.LogRecordDeleted && txn.db.index.Get(record.Key) == nil {
+				continue
+			}
+			records[key] = record
+		}
+		if len(records) == 0 {
+			return nil
+		}
+
+		return txn.db.writeTransaction(records)
+	}, txn.options.SyncWrites || txn.db.options.SyncWrite)
+}
+
+// Rollback 回滚事务，丢弃暂存的数据
+func (txn *Txn) Rollback() {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	txn.finished = true
+	txn.readSet = map[string]*data.LogRecordPos{}
+	txn.pendingWrites = map[string]*data.LogRecord{}
+}
+
+// NewIterator 初始化事务迭代器，迭代器创建时会合并数据库中的数据和事务中暂存的数据
+func (txn *Txn) NewIterator(opts IteratorOptions) *TxnIterator {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	// 取出数据库中的数据
+	items := make(map[string]*txnIteratorItem)
+	if !txn.finished {
+		dbIter := txn.db.NewIterator(IteratorOptions{Prefix: opts.Prefix})
+		for dbIter.Rewind(); dbIter.Valid(); dbIter.Next() {
+			items[string(dbIter.Key())] = &txnIteratorItem{key: dbIter.Key(), pos: dbIter.indexIter.Value()}
+		}
+		dbIter.Close()
+	}
+
+	// 合并事务中暂存的数据
+	for key, record := range txn.pendingWrites {
+		if !bytes.HasPrefix(record.Key, opts.Prefix) {
+			continue
+		}
+		if record.Type == data.LogRecordDeleted {
+			delete(items, key)
+		} else {
+			items[key] = &txnIteratorItem{key: record.Key, value: record.Value, pending: true}
+		}
+	}
+
+	values := make([]*txnIteratorItem, 0, len(items))
+	for _, item := range items {
+		values = append(values, item)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if opts.Reverse {
+			return bytes.Compare(values[i].key, values[j].key) > 0
+		}
+		return bytes.Compare(values[i].key, values[j].key) < 0
+	})
+
+	return &TxnIterator{
+		txn:     txn,
+		reverse: opts.Reverse,
+		values:  values,
+	}
+}
+
+// 记录读取的key及其位置，只记录第一次读取时的位置
+// 在访问此方法前必须持有事务的互斥锁
+func (txn *Txn) trackRead(key []byte, pos *data.LogRecordPos) {
+	if _, ok := txn.readSet[string(key)]; !ok {
+		txn.readSet[string(key)] = pos
+	}
+}
+
+// 判断两个索引位置是否相同
+func isSamePos(a, b *data.LogRecordPos) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Fid == b.Fid && a.Offset == b.Offset
+}
+
+// TxnIterator 事务迭代器
+type TxnIterator struct {
+	txn       *Txn
+	currIndex int                // 当前遍历的位置
+	reverse   bool               // 是否是反向遍历
+	values    []*txnIteratorItem // 合并后的数据
+}
+
+type txnIteratorItem struct {
+	key     []byte
+	pos     *data.LogRecordPos // 数据库中数据的位置
+	value   []byte             // 事务中暂存的数据
+	pending bool               // 是否是事务中暂存的数据
+}
+
+func (it *TxnIterator) Rewind() {
+	it.currIndex = 0
+}
+
+func (it *TxnIterator) Seek(key []byte) {
+	if it.reverse {
+		it.currIndex = sort.Search(len(it.values), func(i int) bool {
+			return bytes.Compare(it.values[i].key, key) <= 0
+		})
+	} else {
+		it.currIndex = sort.Search(len(it.values), func(i int) bool {
+			return bytes.Compare(it.values[i].key, key) >= 0
+		})
+	}
+}
+
+func (it *TxnIterator) Next() {
+	it.currIndex++
+}
+
+func (it *TxnIterator) Valid() bool {
+	return it.currIndex < len(it.values)
+}
+
+func (it *TxnIterator) Key() []byte {
+	return it.values[it.currIndex].key
+}
+
+// Value 读取当前位置的value，读取数据库中的数据会被记录到事务的读集合中
+func (it *TxnIterator) Value() ([]byte, error) {
+	item := it.values[it.currIndex]
+	if item.pending {
+		return item.value, nil
+	}
+
+	it.txn.mu.Lock()
+	defer it.txn.mu.Unlock()
+	if it.txn.finished {
+		return nil, ErrTxnFinished
+	}
+	it.txn.trackRead(item.key, item.pos)
+
+	it.txn.db.mu.RLock()
+	defer it.txn.db.mu.RUnlock()
+	return it.txn.db.getValueByPosition(item.pos)
+}
+
+func (it *TxnIterator) Close() {
+	it.values = nil
+}
@@ -0,0 +1,164 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestTxn_Commit(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-txn-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("100"))
+	assert.Nil(t, err)
+
+	txn := db.NewTxn(DefaultWriteBatchOptions)
+	val, err := txn.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("100"), val)
+
+	// 读取自己未提交的写入
+	err = txn.Put(utils.GetTestKey(1), []byte("80"))
+	assert.Nil(t, err)
+	err = txn.Put(utils.GetTestKey(2), []byte("20"))
+	assert.Nil(t, err)
+	val, err = txn.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("80"), val)
+	err = txn.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	_, err = txn.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 提交之前其他读取不可见
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("100"), val)
+
+	err = txn.Commit()
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("80"), val)
+
+	// 事务结束之后不能再使用
+	err = txn.Put(utils.GetTestKey(3), []byte("1"))
+	assert.Equal(t, ErrTxnFinished, err)
+	err = txn.Commit()
+	assert.Equal(t, ErrTxnFinished, err)
+
+	// 重启之后数据依然存在
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	val, err = db2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("80"), val)
+}
+
+func TestTxn_Conflict(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-txn-2")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("100"))
+	assert.Nil(t, err)
+
+	// 读取过的key被其他写入修改
+	txn1 := db.NewTxn(DefaultWriteBatchOptions)
+	_, err = txn1.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn1.Put(utils.GetTestKey(1), []byte("90"))
+	assert.Nil(t, err)
+
+	txn2 := db.NewTxn(DefaultWriteBatchOptions)
+	_, err = txn2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = txn2.Put(utils.GetTestKey(1), []byte("50"))
+	assert.Nil(t, err)
+
+	err = txn2.Commit()
+	assert.Nil(t, err)
+	err = txn1.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("50"), val)
+
+	// 读取时不存在的key被其他写入创建
+	txn3 := db.NewTxn(DefaultWriteBatchOptions)
+	_, err = txn3.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = txn3.Put(utils.GetTestKey(3), []byte("1"))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), []byte("2"))
+	assert.Nil(t, err)
+	err = txn3.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+	_, err = db.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 只写不读的事务不会冲突
+	txn4 := db.NewTxn(DefaultWriteBatchOptions)
+	err = txn4.Put(utils.GetTestKey(2), []byte("4"))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), []byte("3"))
+	assert.Nil(t, err)
+	err = txn4.Commit()
+	assert.Nil(t, err)
+}
+
+func TestTxn_Iterator(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-txn-3")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	_ = db.Put([]byte("a"), []byte("a"))
+	_ = db.Put([]byte("b"), []byte("b"))
+	_ = db.Put([]byte("c"), []byte("c"))
+
+	txn := db.NewTxn(DefaultWriteBatchOptions)
+	_ = txn.Delete([]byte("b"))
+	_ = txn.Put([]byte("d"), []byte("d"))
+	_ = txn.Put([]byte("a"), []byte("aa"))
+
+	iterator := txn.NewIterator(DefaultIteratorOptions)
+	var keys, values []string
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		val, err := iterator.Value()
+		assert.Nil(t, err)
+		keys = append(keys, string(iterator.Key()))
+		values = append(values, string(val))
+	}
+	iterator.Close()
+	assert.Equal(t, []string{"a", "c", "d"}, keys)
+	assert.Equal(t, []string{"aa", "c", "d"}, values)
+
+	reverseIter := txn.NewIterator(IteratorOptions{Reverse: true})
+	reverseIter.Seek([]byte("c"))
+	assert.True(t, reverseIter.Valid())
+	assert.Equal(t, []byte("c"), reverseIter.Key())
+	reverseIter.Close()
+
+	// 迭代器读取过的key被修改，提交冲突
+	_ = db.Put([]byte("c"), []byte("cc"))
+	err = txn.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+}
@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"github.com/shirou/gopsutil/disk"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -43,6 +45,11 @@ func AvailableDiskSize() (uint64, error) {
 
 // CopyDir 拷贝数据目录,排除exclude
 func CopyDir(src, dest string, exclude []string) error {
+	return CopyDirContext(context.Background(), src, dest, exclude)
+}
+
+// CopyDirContext 拷贝数据目录,排除exclude,ctx 取消时停止拷贝并返回 ctx.Err()
+func CopyDirContext(ctx context.Context, src, dest string, exclude []string) error {
 	// 目标目标不存在则创建
 	if _, err := os.Stat(dest); os.IsNotExist(err) {
 		if err = os.MkdirAll(dest, os.ModePerm); err != nil {
@@ -51,6 +58,12 @@ func CopyDir(src, dest string, exclude []string) error {
 	}
 
 	return filepath.Walk(src, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		fileName := strings.Replace(path, src, "", 1)
 		if fileName == "" {
 			return nil
@@ -70,10 +83,39 @@ func CopyDir(src, dest string, exclude []string) error {
 			return os.MkdirAll(filepath.Join(dest, fileName), info.Mode())
 		}
 
-		data, err := os.ReadFile(filepath.Join(src, fileName))
-		if err != nil {
-			return err
-		}
-		return os.WriteFile(filepath.Join(dest, fileName), data, info.Mode())
+		return copyFile(ctx, filepath.Join(src, fileName), filepath.Join(dest, fileName), info.Mode())
 	})
 }
+
+// 拷贝单个文件，每次读取之前检查 ctx 是否已经取消
+func copyFile(ctx context.Context, src, dest string, mode fs.FileMode) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = srcFile.Close()
+	}()
+	destFile, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = destFile.Close()
+	}()
+	_, err = io.Copy(destFile, &contextReader{ctx: ctx, reader: srcFile})
+	return err
+}
+
+// 读取之前检查 ctx 是否已经取消的 Reader
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.reader.Read(p)
+}
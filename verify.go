@@ -0,0 +1,386 @@
+package fdb
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/fio"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const maxVerifyErrors = 100 // 每个文件最多记录的错误数量
+
+// VerifyReport 数据目录的检查结果
+type VerifyReport struct {
+	Dir              string              `json:"dir"`
+	DataFiles        []*VerifyFileReport `json:"data_files"`                  // 每个数据文件的检查结果
+	HintFile         *VerifyFileReport   `json:"hint_file,omitempty"`         // hint 文件的检查结果，记录指向的数据不匹配时计为损坏
+	NonMergeFileId   *uint32             `json:"non_merge_file_id,omitempty"` // merge-finished 文件中记录的文件ID
+	SeqNo            *uint64             `json:"seq_no,omitempty"`            // seq-no 文件中记录的事务序列号
+	MaxSeqNo         uint64              `json:"max_seq_no"`                  // 数据文件中最大的事务序列号
+	MergeDir         string              `json:"merge_dir,omitempty"`         // 存在的 merge 目录
+	MergeDirFinished bool                `json:"merge_dir_finished"`          // merge 目录中的 merge 是否已经完成，完成的 merge 在下次启动时生效
+	Problems         []string            `json:"problems"`                    // 文件之间不一致的问题
+}
+
+// VerifyFileReport 单个文件的检查结果
+type VerifyFileReport struct {
+	Name         string   `json:"name"`
+	Records      int      `json:"records"`          // 完好的记录数量
+	BadRecords   int      `json:"bad_records"`      // 损坏的记录数量
+	CorruptBytes int64    `json:"corrupt_bytes"`    // 损坏的数据大小
+	Errors       []string `json:"errors,omitempty"` // 损坏的位置及原因，最多记录 maxVerifyErrors 条
+}
+
+// OK 数据目录中是否没有发现任何问题
+func (r *VerifyReport) OK() bool {
+	if len(r.Problems) > 0 {
+		return false
+	}
+	if r.HintFile != nil && r.HintFile.BadRecords > 0 {
+		return false
+	}
+	for _, file := range r.DataFiles {
+		if file.BadRecords > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *VerifyFileReport) addError(offset int64, err error) {
+	f.BadRecords++
+	if len(f.Errors) < maxVerifyErrors {
+		f.Errors = append(f.Errors, fmt.Sprintf("offset %d: %v", offset, err))
+	}
+}
+
+// Verify 离线检查数据目录，所有文件都以只读方式打开，不会修改数据目录，需要在数据库关闭的时候执行
+// 没有权限读取的文件直接返回错误
+// 检查每个数据文件中记录的 CRC 及 header，hint 文件中的索引是否指向对应的记录，
+// merge-finished、seq-no 文件是否和数据文件一致，以及是否有遗留的 merge 目录
+// 返回的错误表示无法进行检查，数据损坏的情况记录在检查结果中
+func Verify(dir string, opts VerifyOptions) (*VerifyReport, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	cipher, err := verifyCipher(dir, opts.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	report := &VerifyReport{Dir: dir, Problems: []string{}}
+
+	// 1.检查所有的数据文件
+	dataFiles, err := openVerifyDataFiles(dir, cipher, fio.ReadOnlyFIO)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, dataFile := range dataFiles {
+			_ = dataFile.Close()
+		}
+	}()
+	fileIds := make([]uint32, 0, len(dataFiles))
+	for fid := range dataFiles {
+		fileIds = append(fileIds, fid)
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+	for _, fid := range fileIds {
+		fileReport, maxSeqNo, err := verifyDataFile(dataFiles[fid])
+		if err != nil {
+			return nil, err
+		}
+		if maxSeqNo > report.MaxSeqNo {
+			report.MaxSeqNo = maxSeqNo
+		}
+		report.DataFiles = append(report.DataFiles, fileReport)
+	}
+
+	// 2.检查 merge-finished 和 hint 文件
+	if err = verifyMergeFiles(dir, cipher, report, fileIds, dataFiles); err != nil {
+		return nil, err
+	}
+
+	// 3.seq-no 文件中的序列号不能落后于数据文件
+	seqNoExists, err := fileExists(filepath.Join(dir, data.SeqNoFileName))
+	if err != nil {
+		return nil, err
+	}
+	if seqNoExists {
+		seqNoFile, err := data.OpenSeqNoFile(dir, fio.ReadOnlyFIO)
+		if err != nil {
+			return nil, err
+		}
+		seqNoFile.Cipher = cipher
+		seqNo, err := readLastSeqNo(seqNoFile)
+		_ = seqNoFile.Close()
+		if err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s is corrupted: %v", data.SeqNoFileName, err))
+		} else {
+			report.SeqNo = &seqNo
+			if seqNo < report.MaxSeqNo {
+				report.Problems = append(report.Problems, fmt.Sprintf("%s %d is behind the max seq no %d in data files",
+					data.SeqNoFileName, seqNo, report.MaxSeqNo))
+			}
+		}
+	}
+
+	// 4.遗留的 merge 目录
+	mergePath := path.Join(path.Dir(path.Clean(dir)), path.Base(dir)+mergeDirName)
+	info, err := os.Stat(mergePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil && info.IsDir() {
+		report.MergeDir = mergePath
+		report.MergeDirFinished, err = fileExists(filepath.Join(mergePath, data.MergeFinishedFileName))
+		if err != nil {
+			return nil, err
+		}
+		if !report.MergeDirFinished {
+			report.Problems = append(report.Problems, fmt.Sprintf("orphaned merge directory %s", mergePath))
+		}
+	}
+
+	return report, nil
+}
+
+// 校验加密密钥，不会像 Open 一样创建校验密钥的文件
+func verifyCipher(dir string, key []byte) (*data.Cipher, error) {
+	keyCheckFileName := filepath.Join(dir, data.KeyCheckFileName)
+	keyCheckExists, err := fileExists(keyCheckFileName)
+	if err != nil || !keyCheckExists {
+		return nil, err
+	}
+	if len(key) == 0 {
+		return nil, ErrEncryptionKeyRequired
+	}
+	cipher, err := data.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	keyCheckFile, err := data.OpenKeyCheckFile(dir, fio.ReadOnlyFIO)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = keyCheckFile.Close()
+	}()
+	keyCheckFile.Cipher = cipher
+	record, _, err := keyCheckFile.ReadLogRecord(0)
+	if err != nil || !bytes.Equal(record.Value, keyCheckValue) {
+		return nil, ErrInvalidEncryptionKey
+	}
+	return cipher, nil
+}
+
+func openVerifyDataFiles(dir string, cipher *data.Cipher, ioType fio.FileIOType) (map[uint32]*data.DataFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	dataFiles := make(map[uint32]*data.DataFile)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			continue
+		}
+		fid, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.DataFileNameSuffix))
+		if err != nil {
+			continue
+		}
+		dataFile, err := data.OpenDataFile(dir, uint32(fid), ioType)
+		if err != nil {
+			for _, opened := range dataFiles {
+				_ = opened.Close()
+			}
+			return nil, err
+		}
+		dataFile.Cipher = cipher
+		dataFiles[uint32(fid)] = dataFile
+	}
+	return dataFiles, nil
+}
+
+// 遍历数据文件中的所有记录，损坏的记录根据 header 中的大小跳过，header 也损坏时剩下的数据全部计为损坏
+func verifyDataFile(dataFile *data.DataFile) (*VerifyFileReport, uint64, error) {
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return nil, 0, err
+	}
+	report := &VerifyFileReport{Name: filepath.Base(data.GetDataFileName("", dataFile.FileId))}
+	var offset int64
+	var maxSeqNo uint64
+	for offset < fileSize {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err == nil {
+			if logRecord.Type > data.LogRecordBlobRef {
+				report.addError(offset, fmt.Errorf("unknown log record type %d", logRecord.Type))
+			} else {
+				report.Records++
+			}
+			if _, seqNo := parseLogRecordKey(logRecord.Key); seqNo > maxSeqNo {
+				maxSeqNo = seqNo
+			}
+			offset += size
+			continue
+		}
+		if err == io.EOF { // 末尾的数据不完整
+			report.addError(offset, io.ErrUnexpectedEOF)
+			report.CorruptBytes += fileSize - offset
+			break
+		}
+		report.addError(offset, err)
+		recordSize, sizeErr := dataFile.ReadLogRecordSize(offset)
+		if sizeErr != nil {
+			report.CorruptBytes += fileSize - offset
+			break
+		}
+		report.CorruptBytes += recordSize
+		offset += recordSize
+	}
+	return report, maxSeqNo, nil
+}
+
+// hint 文件和 merge-finished 文件同时存在，hint 文件中的索引只能指向 merge 过的数据文件中对应的记录
+func verifyMergeFiles(dir string, cipher *data.Cipher, report *VerifyReport, fileIds []uint32,
+	dataFiles map[uint32]*data.DataFile) error {
+	mergeFinished, err := fileExists(filepath.Join(dir, data.MergeFinishedFileName))
+	if err != nil {
+		return err
+	}
+	hintExists, err := fileExists(filepath.Join(dir, data.HintFileName))
+	if err != nil {
+		return err
+	}
+	if !mergeFinished {
+		if hintExists {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s exists without %s",
+				data.HintFileName, data.MergeFinishedFileName))
+		}
+		return nil
+	}
+
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(dir, fio.ReadOnlyFIO)
+	if err != nil {
+		return err
+	}
+	mergeFinishedFile.Cipher = cipher
+	record, _, err := mergeFinishedFile.ReadLogRecord(0)
+	_ = mergeFinishedFile.Close()
+	if err != nil {
+		report.Problems = append(report.Problems, fmt.Sprintf("%s is corrupted: %v", data.MergeFinishedFileName, err))
+		return nil
+	}
+	fid, err := strconv.ParseUint(string(record.Value), 10, 32)
+	if err != nil {
+		report.Problems = append(report.Problems, fmt.Sprintf("%s is corrupted: %v", data.MergeFinishedFileName, err))
+		return nil
+	}
+	nonMergeFileId := uint32(fid)
+	report.NonMergeFileId = &nonMergeFileId
+	// merge 时会打开ID为 nonMergeFileId 的新的活跃文件
+	if len(fileIds) == 0 || fileIds[len(fileIds)-1] < nonMergeFileId {
+		report.Problems = append(report.Problems, fmt.Sprintf("%s %d is beyond the last data file",
+			data.MergeFinishedFileName, nonMergeFileId))
+	}
+	if !hintExists {
+		report.Problems = append(report.Problems, fmt.Sprintf("%s exists without %s",
+			data.MergeFinishedFileName, data.HintFileName))
+		return nil
+	}
+
+	hintFile, err := data.OpenHintFile(dir, fio.ReadOnlyFIO)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = hintFile.Close()
+	}()
+	hintFile.Cipher = cipher
+	hintSize, err := hintFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	hintReport := &VerifyFileReport{Name: data.HintFileName}
+	report.HintFile = hintReport
+	var offset int64
+	for offset < hintSize {
+		hintRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			hintReport.addError(offset, err)
+			hintReport.CorruptBytes += hintSize - offset
+			break
+		}
+		if err := verifyHintRecord(hintRecord, nonMergeFileId, dataFiles); err != nil {
+			hintReport.addError(offset, err)
+		} else {
+			hintReport.Records++
+		}
+		offset += size
+	}
+	return nil
+}
+
+// 检查 hint 文件中的索引是否指向 key 相同、大小一致的有效记录
+func verifyHintRecord(hintRecord *data.LogRecord, nonMergeFileId uint32, dataFiles map[uint32]*data.DataFile) error {
+	pos := data.DecodeLogRecordPos(hintRecord.Value)
+	if pos.Fid >= nonMergeFileId {
+		return fmt.Errorf("key %q points at file %d which was not merged", hintRecord.Key, pos.Fid)
+	}
+	dataFile := dataFiles[pos.Fid]
+	if dataFile == nil {
+		return fmt.Errorf("key %q points at missing file %d", hintRecord.Key, pos.Fid)
+	}
+	logRecord, size, err := dataFile.ReadLogRecord(pos.Offset)
+	if err != nil {
+		return fmt.Errorf("key %q points at invalid record in file %d: %v", hintRecord.Key, pos.Fid, err)
+	}
+	if realKey, _ := parseLogRecordKey(logRecord.Key); !bytes.Equal(realKey, hintRecord.Key) || size != int64(pos.Size) {
+		return fmt.Errorf("key %q does not match the record at file %d offset %d", hintRecord.Key, pos.Fid, pos.Offset)
+	}
+	return nil
+}
+
+// 每次关闭数据库时都会追加一条记录，最后一条才是最新的序列号
+func readLastSeqNo(seqNoFile *data.DataFile) (uint64, error) {
+	var offset int64
+	var last *data.LogRecord
+	for {
+		record, size, err := seqNoFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, err
+		}
+		last = record
+		offset += size
+	}
+	if last == nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return strconv.ParseUint(string(last.Value), 10, 64)
+}
+
+// 文件是否存在，没有权限等其他错误直接返回，不当作文件不存在
+func fileExists(fileName string) (bool, error) {
+	_, err := os.Stat(fileName)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
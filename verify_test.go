@@ -0,0 +1,156 @@
+package fdb
+
+import (
+	"github.com/calmw/fdb/data"
+	"github.com/calmw/fdb/fio"
+	"github.com/calmw/fdb/utils"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-verify")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 100; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = wb.Commit()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 1.merge 完成但是还没有生效
+	report, err := Verify(dir, DefaultVerifyOptions)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.True(t, report.MergeDirFinished)
+	assert.Equal(t, uint64(1), report.MaxSeqNo)
+
+	// 重启之后 merge 生效
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+	report, err = Verify(dir, DefaultVerifyOptions)
+	assert.Nil(t, err)
+	assert.True(t, report.OK(), report.Problems)
+	assert.NotNil(t, report.NonMergeFileId)
+	assert.NotNil(t, report.HintFile)
+	assert.Equal(t, 500, report.HintFile.Records)
+	assert.Equal(t, uint64(1), *report.SeqNo)
+	var records int
+	for _, file := range report.DataFiles {
+		records += file.Records
+	}
+	assert.Equal(t, 500+100+1, records)
+	assert.Empty(t, report.MergeDir)
+
+	// 2.损坏第一个数据文件中的一条记录，之后的记录依然可以检查
+	fileName := data.GetDataFileName(dir, 0)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	content[100] ^= 0xff
+	err = os.WriteFile(fileName, content, 0644)
+	assert.Nil(t, err)
+	// 遗留的没有完成的 merge 目录
+	err = os.MkdirAll(dir+mergeDirName, os.ModePerm)
+	assert.Nil(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir + mergeDirName)
+	}()
+
+	report, err = Verify(dir, DefaultVerifyOptions)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, 1, report.DataFiles[0].BadRecords)
+	assert.Greater(t, report.DataFiles[0].Records, 0)
+	assert.Equal(t, 1, report.HintFile.BadRecords)
+	assert.Equal(t, dir+mergeDirName, report.MergeDir)
+	assert.Len(t, report.Problems, 1)
+}
+
+func TestVerify_ReadOnly(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-verify-read-only")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 500; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	// merge 的结果生效，数据目录中有 hint、merge-finished 和 seq-no 文件
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	modTimes := make(map[string]int64)
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		assert.Nil(t, err)
+		modTimes[entry.Name()] = info.ModTime().UnixNano()
+	}
+
+	report, err := Verify(dir, DefaultVerifyOptions)
+	assert.Nil(t, err)
+	assert.True(t, report.OK(), report.Problems)
+	assert.NotNil(t, report.HintFile)
+
+	// 数据文件以只读方式打开，无法写入
+	dataFiles, err := openVerifyDataFiles(dir, nil, fio.ReadOnlyFIO)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, dataFiles)
+	for _, dataFile := range dataFiles {
+		_, err = dataFile.IoManager.Write([]byte("x"))
+		assert.NotNil(t, err)
+		_ = dataFile.Close()
+	}
+
+	// 数据目录没有任何变化
+	entries2, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), len(entries2))
+	for _, entry := range entries2 {
+		info, err := entry.Info()
+		assert.Nil(t, err)
+		assert.Equal(t, modTimes[entry.Name()], info.ModTime().UnixNano(), entry.Name())
+	}
+
+	// 文件不存在以外的错误不会被当作文件不存在
+	exists, err := fileExists(filepath.Join(data.GetDataFileName(dir, 0), data.HintFileName))
+	assert.NotNil(t, err)
+	assert.False(t, exists)
+	exists, err = fileExists(filepath.Join(dir, data.HintFileName))
+	assert.Nil(t, err)
+	assert.True(t, exists)
+}
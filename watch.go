@@ -0,0 +1,121 @@
+package fdb
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+// WatchEvent key的变更事件
+type WatchEvent struct {
+	Key     []byte
+	Value   []byte // 新的value，删除事件为空
+	Deleted bool   // 是否是删除事件
+	SeqNo   uint64 // 写入序列号，按照提交的顺序单调递增，同一个 WriteBatch、Txn 或者范围删除产生的事件序列号相同，重启之后重新开始计数
+}
+
+// Watcher 订阅指定前缀的key的变更，Put、Delete、WriteBatch 及 Txn 的提交都会产生事件
+// 事件按照提交的顺序发送，消费者处理过慢导致缓冲区满时，新的事件会被丢弃并计数
+type Watcher struct {
+	db      *DB
+	prefix  []byte
+	events  chan *WatchEvent
+	dropped uint64 // 被丢弃的事件数量
+	closed  bool
+}
+
+// Watch 订阅指定前缀的key的变更，前缀为空表示订阅所有的key，使用完之后需要调用 Close 取消订阅
+func (db *DB) Watch(prefix []byte, opts WatchOptions) *Watcher {
+	bufferSize := opts.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = 1
+	}
+	w := &Watcher{
+		db:     db,
+		prefix: prefix,
+		events: make(chan *WatchEvent, bufferSize),
+	}
+
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	db.watchers[w] = struct{}{}
+
+	return w
+}
+
+// Events 返回接收事件的通道，取消订阅或者数据库关闭之后通道会被关闭
+func (w *Watcher) Events() <-chan *WatchEvent {
+	return w.events
+}
+
+// Dropped 返回由于缓冲区满而被丢弃的事件数量
+func (w *Watcher) Dropped() uint64 {
+	return atomic.LoadUint64(&w.dropped)
+}
+
+// Close 取消订阅
+func (w *Watcher) Close() {
+	w.db.watchMu.Lock()
+	defer w.db.watchMu.Unlock()
+	w.close()
+}
+
+// 在访问此方法前必须持有 watchMu 互斥锁
+func (w *Watcher) close() {
+	if w.closed {
+		return
+	}
+	w.closed = true
+	delete(w.db.watchers, w)
+	close(w.events)
+}
+
+// 通知所有订阅了该key的观察者，不会阻塞写入
+// 组提交期间事件先暂存起来，持久化成功之后才发送
+// 在访问此方法前必须持有互斥锁，保证事件的顺序和提交的顺序一致
+func (db *DB) notifyWatchers(key, value []byte, deleted bool, seqNo uint64) {
+	db.watchMu.RLock()
+	noWatchers := len(db.watchers) == 0
+	db.watchMu.RUnlock()
+	if noWatchers {
+		return
+	}
+
+	// 拷贝一份 key 和 value，调用方之后修改传入的切片不会影响事件
+	event := &WatchEvent{
+		Key:     append([]byte{}, key...),
+		Value:   append([]byte(nil), value...),
+		Deleted: deleted,
+		SeqNo:   seqNo,
+	}
+	if db.syncDeferred {
+		db.commitEvents = append(db.commitEvents, event)
+		return
+	}
+	db.sendWatchEvent(event)
+}
+
+// 将事件发送给订阅了该key的观察者
+// 在访问此方法前必须持有互斥锁
+func (db *DB) sendWatchEvent(event *WatchEvent) {
+	db.watchMu.RLock()
+	defer db.watchMu.RUnlock()
+	for w := range db.watchers {
+		if !bytes.HasPrefix(event.Key, w.prefix) {
+			continue
+		}
+		select {
+		case w.events <- event:
+		default:
+			atomic.AddUint64(&w.dropped, 1)
+		}
+	}
+}
+
+// 关闭所有的观察者
+func (db *DB) closeWatchers() {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	for w := range db.watchers {
+		w.close()
+	}
+}
@@ -0,0 +1,82 @@
+package fdb
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDB_Watch(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-watch")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	w := db.Watch([]byte("user:"), DefaultWatchOptions)
+	defer w.Close()
+
+	// 事件中的 value 是拷贝，写入之后修改传入的切片不影响事件
+	value := []byte("a")
+	err = db.Put([]byte("user:1"), value)
+	assert.Nil(t, err)
+	value[0] = 'x'
+	err = db.Put([]byte("order:1"), []byte("b"))
+	assert.Nil(t, err)
+	err = db.Delete([]byte("user:1"))
+	assert.Nil(t, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put([]byte("user:2"), []byte("c"))
+	err = wb.Commit()
+	assert.Nil(t, err)
+
+	// 每次写入都有单调递增的序列号
+	event := <-w.Events()
+	assert.Equal(t, []byte("user:1"), event.Key)
+	assert.Equal(t, []byte("a"), event.Value)
+	assert.False(t, event.Deleted)
+	assert.Greater(t, event.SeqNo, nonTransactionSeqNo)
+	seqNo := event.SeqNo
+	event = <-w.Events()
+	assert.Equal(t, []byte("user:1"), event.Key)
+	assert.True(t, event.Deleted)
+	assert.Greater(t, event.SeqNo, seqNo)
+	seqNo = event.SeqNo
+	event = <-w.Events()
+	assert.Equal(t, []byte("user:2"), event.Key)
+	assert.Equal(t, []byte("c"), event.Value)
+	assert.Greater(t, event.SeqNo, seqNo)
+	assert.Equal(t, 0, len(w.Events()))
+}
+
+func TestDB_Watch_Drop(t *testing.T) {
+	opts := DefaultOption
+	dir, _ := os.MkdirTemp("", "fdb-go-watch-drop")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 缓冲区满了之后丢弃事件，不阻塞写入
+	w := db.Watch(nil, WatchOptions{BufferSize: 2})
+	for i := 0; i < 5; i++ {
+		err := db.Put([]byte("key"), []byte("value"))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 2, len(w.Events()))
+	assert.Equal(t, uint64(3), w.Dropped())
+
+	// 取消订阅之后通道被关闭
+	w.Close()
+	var count int
+	for range w.Events() {
+		count++
+	}
+	assert.Equal(t, 2, count)
+	err = db.Put([]byte("key"), []byte("value"))
+	assert.Nil(t, err)
+}